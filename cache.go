@@ -0,0 +1,166 @@
+package grpc
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/shlsky/xk6-grpc/xgrpc_conn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// parsedDescriptors are the file descriptors and methods of a descriptor set,
+// they are immutable so a single instance is shared by every VU.
+type parsedDescriptors struct {
+	// fdset is the source of the files, a client builds it with its own registry when the files
+	// are nil, i.e. when the set imports files of a previous load, or when it already holds
+	// other instances of the files.
+	fdset   *descriptorpb.FileDescriptorSet
+	files   []protoreflect.FileDescriptor
+	methods []MethodInfo
+}
+
+type descriptorCacheEntry struct {
+	once   sync.Once
+	parsed *parsedDescriptors
+	err    error
+}
+
+// descriptorCache is the process-wide cache of the parsed descriptor sets, so the init
+// context of each VU doesn't decode and build the same descriptors again. The sets given
+// as a source, a descriptor or a protoset are keyed by the hash of their content, the proto
+// files loaded by LoadProto by the hash of their paths, see LoadProto.
+var descriptorCache sync.Map //nolint:gochecknoglobals
+
+func descriptorCacheKey(kind string, parts ...[]byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(kind))
+	for _, p := range parts {
+		// the length prefix prevents the collisions between different splits
+		_, _ = fmt.Fprintf(h, "\x00%d\x00", len(p))
+		h.Write(p)
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// cachedDescriptors returns the parsed descriptors identified by key,
+// parse is only called by the first VU loading them.
+func cachedDescriptors(
+	key [sha256.Size]byte,
+	parse func() (*descriptorpb.FileDescriptorSet, error),
+) (*parsedDescriptors, error) {
+	v, _ := descriptorCache.LoadOrStore(key, &descriptorCacheEntry{})
+	entry := v.(*descriptorCacheEntry) //nolint:forcetypeassert
+	entry.once.Do(func() {
+		var fdset *descriptorpb.FileDescriptorSet
+		fdset, entry.err = parse()
+		if entry.err != nil {
+			return
+		}
+		entry.parsed = buildDescriptors(fdset)
+	})
+	return entry.parsed, entry.err
+}
+
+// sharedFiles holds the files of the cached sets keyed by the hash of their content and of
+// their imports, so the sets importing the same files share their instances.
+var sharedFiles sync.Map //nolint:gochecknoglobals
+
+func buildDescriptors(fdset *descriptorpb.FileDescriptorSet) *parsedDescriptors {
+	files, err := buildSharedFiles(fdset)
+	if err != nil {
+		// the set can't be built on its own, each client will try with the files it already loaded
+		return &parsedDescriptors{fdset: fdset}
+	}
+
+	return &parsedDescriptors{fdset: fdset, files: files, methods: collectMethods(files)}
+}
+
+// buildSharedFiles builds the files of the set, with their imports first. A file with the same
+// content and imports as a file of another set is the instance built for the first of them.
+func buildSharedFiles(fdset *descriptorpb.FileDescriptorSet) ([]protoreflect.FileDescriptor, error) {
+	pending := make(map[string]*descriptorpb.FileDescriptorProto, len(fdset.GetFile()))
+	for _, fdp := range fdset.GetFile() {
+		pending[fdp.GetName()] = fdp
+	}
+	registry := xgrpc_conn.NewRegistry()
+	keys := make(map[string][sha256.Size]byte, len(pending))
+	files := make([]protoreflect.FileDescriptor, 0, len(pending))
+
+	var build func(name string, importedBy []string) error
+	build = func(name string, importedBy []string) error {
+		fdp, ok := pending[name]
+		if !ok {
+			// built already, or resolved by the registry with the files linked into the binary
+			return nil
+		}
+		for _, n := range importedBy {
+			if n == name {
+				return fmt.Errorf("import cycle in %q", name)
+			}
+		}
+		parts := make([][]byte, 0, len(fdp.GetDependency())+1)
+		for _, dep := range fdp.GetDependency() {
+			if err := build(dep, append(importedBy, name)); err != nil {
+				return err
+			}
+			if key, ok := keys[dep]; ok {
+				parts = append(parts, key[:])
+			} else {
+				parts = append(parts, []byte(dep))
+			}
+		}
+		delete(pending, name)
+
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(fdp)
+		if err != nil {
+			return err
+		}
+		key := descriptorCacheKey("file", append(parts, b)...)
+		keys[name] = key
+		shared, ok := sharedFiles.Load(key)
+		if !ok {
+			fd, err := protodesc.NewFile(fdp, registry)
+			if err != nil {
+				return err
+			}
+			shared, _ = sharedFiles.LoadOrStore(key, fd)
+		}
+		fd := shared.(protoreflect.FileDescriptor) //nolint:forcetypeassert
+		if err = registry.RegisterFiles([]protoreflect.FileDescriptor{fd}); err != nil {
+			return err
+		}
+		files = append(files, fd)
+		return nil
+	}
+
+	for _, fdp := range fdset.GetFile() {
+		if err := build(fdp.GetName(), nil); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// addDescriptors makes the parsed descriptors available to the client.
+func (c *Client) addDescriptors(parsed *parsedDescriptors) ([]MethodInfo, error) {
+	if parsed.files == nil {
+		return c.convertToMethodInfo(parsed.fdset)
+	}
+
+	if _, err := c.base().LoadFiles(parsed.files); err != nil {
+		if errors.Is(err, xgrpc_conn.ErrFileLoaded) {
+			// the client holds other instances of the files, the methods must resolve to them
+			return c.convertToMethodInfo(parsed.fdset)
+		}
+		return nil, err
+	}
+	// a copy, so a script can't change the instances shared with the other VUs
+	return append([]MethodInfo(nil), parsed.methods...), nil
+}
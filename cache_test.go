@@ -0,0 +1,146 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestDescriptorCache(t *testing.T) {
+	t.Parallel()
+
+	fd := parseTestProto(t, `syntax = "proto3";
+package cache.test;
+message Ping { string id = 1; }
+service Pinger { rpc Ping(cache.test.Ping) returns (cache.test.Ping); }
+`)
+	fdset := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(fd)}}
+
+	calls := 0
+	parse := func() (*descriptorpb.FileDescriptorSet, error) {
+		calls++
+		return fdset, nil
+	}
+	key := descriptorCacheKey("test", []byte(t.Name()))
+	first, err := cachedDescriptors(key, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cachedDescriptors(key, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || first != second {
+		t.Fatalf("expected a single parse, got %d", calls)
+	}
+
+	a, b := &Client{}, &Client{}
+	methodsA, err := a.addDescriptors(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.addDescriptors(second); err != nil {
+		t.Fatal(err)
+	}
+	if len(methodsA) != 1 || methodsA[0].FullMethod != "/cache.test.Pinger/Ping" {
+		t.Fatalf("unexpected methods %v", methodsA)
+	}
+	mdA, _ := a.base().Method("/cache.test.Pinger/Ping")
+	mdB, _ := b.base().Method("/cache.test.Pinger/Ping")
+	if mdA == nil || mdA != mdB {
+		t.Fatal("the method descriptors are not shared")
+	}
+	methodsA[0].Name = "changed"
+	if first.methods[0].Name != "Ping" {
+		t.Fatal("the shared methods were modified")
+	}
+	if _, err = a.types().FindMessageByName("cache.test.Ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	if descriptorCacheKey("k", []byte("ab"), []byte("c")) == descriptorCacheKey("k", []byte("a"), []byte("bc")) {
+		t.Fatal("the keys of different parts collide")
+	}
+}
+
+func TestDescriptorCacheSharedImports(t *testing.T) {
+	t.Parallel()
+
+	sources := map[string]string{
+		"cache/shared.proto": `syntax = "proto3";
+package cache.shared;
+message Item { string id = 1; }
+`,
+		"cache/a.proto": `syntax = "proto3";
+package cache.a;
+import "cache/shared.proto";
+service A { rpc Get(cache.shared.Item) returns (cache.shared.Item); }
+`,
+		"cache/b.proto": `syntax = "proto3";
+package cache.b;
+import "cache/shared.proto";
+service B { rpc Get(cache.shared.Item) returns (cache.shared.Item); }
+`,
+	}
+	load := func(name string) *parsedDescriptors {
+		t.Helper()
+		parsed, err := cachedDescriptors(descriptorCacheKey("test", []byte(t.Name()), []byte(name)),
+			func() (*descriptorpb.FileDescriptorSet, error) {
+				return compileProtos(&protocompile.SourceResolver{
+					Accessor: protocompile.SourceAccessorFromMap(sources),
+				}, name)
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	a, b := load("cache/a.proto"), load("cache/b.proto")
+	if a.files == nil || b.files == nil {
+		t.Fatal("the sets should build on their own")
+	}
+
+	c := &Client{}
+	for _, parsed := range []*parsedDescriptors{a, b} {
+		if _, err := c.addDescriptors(parsed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	item, err := c.base().Registry().FindDescriptorByName("cache.shared.Item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"/cache.a.A/Get", "/cache.b.B/Get"} {
+		md, err := c.base().Method(method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Input() != item {
+			t.Fatalf("%s should use the registered instance of the shared import", method)
+		}
+	}
+
+	// a client holding another instance of the import builds the set with it
+	other := &Client{}
+	shared, err := compileProtos(&protocompile.SourceResolver{
+		Accessor: protocompile.SourceAccessorFromMap(sources),
+	}, "cache/shared.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.convertToMethodInfo(shared); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.addDescriptors(b); err != nil {
+		t.Fatal(err)
+	}
+	item, err = other.base().Registry().FindDescriptorByName("cache.shared.Item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md, _ := other.base().Method("/cache.b.B/Get"); md == nil || md.Input() != item {
+		t.Fatal("the method should use the client's instance of the shared import")
+	}
+}
@@ -6,8 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
-	"github.com/jhump/protoreflect/desc/protoparse"
 	"io"
+	"net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -18,37 +19,90 @@ import (
 	"go.k6.io/k6/lib/types"
 	"go.k6.io/k6/metrics"
 
+	"github.com/bufbuild/protocompile"
 	"github.com/grafana/sobek"
 	"github.com/jhump/protoreflect/desc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
-	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 // Client represents a gRPC client that can be used to make RPC requests
 type Client struct {
-	mds  map[string]protoreflect.MethodDescriptor
-	conn *xgrpc_conn.Conn
-	addr string
-	vu   modules.VU
+	// client holds the loaded descriptors and the connection, see base.
+	client *xgrpc_conn.Client
+	// pooled is the entry of the connection when it is shared with shareConn.
+	pooled   *pooledConn
+	addr     string
+	vu       modules.VU
+	defaults invokeDefaults
+	// constraints caches the validation rules of the fields
+	constraints constraintCache
+	// metrics are the custom metrics, unset without an init environment
+	metrics instanceMetrics
 }
 
 // NewClient is the JS constructor for the grpc Client.
 func (mi *ModuleInstance) NewClient(_ sobek.ConstructorCall) *sobek.Object {
 	rt := mi.vu.Runtime()
-	return rt.ToValue(&Client{vu: mi.vu}).ToObject(rt)
+	client := &Client{
+		client:   xgrpc_conn.NewClientWithConn(nil),
+		vu:       mi.vu,
+		defaults: invokeDefaults{JSONOptions: xgrpc_conn.DefaultJSONOptions()},
+		metrics:  mi.metrics,
+	}
+	return rt.ToValue(client).ToObject(rt)
+}
+
+// pooledConn is a connection shared by the VUs connecting to the same address with shareConn.
+type pooledConn struct {
+	conn *xgrpc_conn.Conn
+	// ctx bounds the tracking of the connectivity state, it is done when the connection is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-var connectionPool = make(map[string]*xgrpc_conn.Conn)
+var connectionPool = make(map[string]*pooledConn)
 var lck sync.Mutex
 
+// pooledConnection returns the pooled connection to addr, connected with connect by the first VU.
+// Its connectivity state is tracked by tracker with the pool entry's context, for the VUs sharing it.
+func pooledConnection(
+	addr string, tracker *xgrpc_conn.StateTracker, connect func() (*xgrpc_conn.Conn, error),
+) (*pooledConn, error) {
+	lck.Lock()
+	defer lck.Unlock()
+	if pooled, ok := connectionPool[addr]; ok {
+		return pooled, nil
+	}
+	conn, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pooled := &pooledConn{conn: conn, ctx: ctx, cancel: cancel}
+	connectionPool[addr] = pooled
+	tracker.Track(ctx, conn)
+	return pooled, nil
+}
+
+// close closes the connection and removes it from the pool, the next VU connecting opens a new one.
+func (p *pooledConn) close(addr string) error {
+	lck.Lock()
+	if connectionPool[addr] == p {
+		delete(connectionPool, addr)
+	}
+	lck.Unlock()
+	p.cancel()
+	return p.conn.Close()
+}
+
 // Load will parse the given proto files and make the file descriptors available to request.
 func (c *Client) LoadProto(importPaths []string, filenames ...string) ([]MethodInfo, error) {
 	if c.vu.State() != nil {
@@ -65,27 +119,44 @@ func (c *Client) LoadProto(importPaths []string, filenames ...string) ([]MethodI
 		importPaths = append(importPaths, initEnv.CWD.Path)
 	}
 
-	parser := protoparse.Parser{
-		ImportPaths:      importPaths,
-		InferImportPaths: false,
-		Accessor: protoparse.FileAccessor(func(filename string) (io.ReadCloser, error) {
-			absFilePath := initEnv.GetAbsFilePath(filename)
-			return initEnv.FileSystems["file"].Open(absFilePath)
-		}),
+	// the files are identified by their paths and not by their content, which would need
+	// to be compiled to know the imports: the file system of the init context caches the
+	// files it reads, so a path is a single content for the whole test run
+	keyParts := [][]byte{[]byte(initEnv.CWD.String())}
+	for _, p := range importPaths {
+		keyParts = append(keyParts, []byte(p))
 	}
-
-	fds, err := parser.ParseFiles(filenames...)
+	keyParts = append(keyParts, nil)
+	for _, f := range filenames {
+		keyParts = append(keyParts, []byte(f))
+	}
+	key := descriptorCacheKey("proto", keyParts...)
+	parsed, err := cachedDescriptors(key, func() (*descriptorpb.FileDescriptorSet, error) {
+		return parseProtoFiles(initEnv, importPaths, filenames)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return c.addDescriptors(parsed)
+}
 
-	fdset := &descriptorpb.FileDescriptorSet{}
+// LoadProtoString will compile the given proto source and make its file descriptors available to request.
+// It can import the well-known types and the google/api files.
+func (c *Client) LoadProtoString(name, source string) ([]MethodInfo, error) {
+	if c.vu.State() != nil {
+		return nil, errors.New("load must be called in the init context")
+	}
 
-	seen := make(map[string]struct{})
-	for _, fd := range fds {
-		fdset.File = append(fdset.File, walkFileDescriptors(seen, fd)...)
+	key := descriptorCacheKey("string", []byte(name), []byte(source))
+	parsed, err := cachedDescriptors(key, func() (*descriptorpb.FileDescriptorSet, error) {
+		return compileProtos(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{name: source}),
+		}, name)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return c.convertToMethodInfo(fdset)
+	return c.addDescriptors(parsed)
 }
 
 // Load will parse the given proto files and make the file descriptors available to request.
@@ -99,23 +170,24 @@ func (c *Client) Load(descriptor string) ([]MethodInfo, error) {
 		return nil, errors.New("missing init environment")
 	}
 
-	bb, err := base64.StdEncoding.DecodeString(descriptor)
-	if err != nil {
-		return nil, err
-	}
+	key := descriptorCacheKey("base64", []byte(descriptor))
+	parsed, err := cachedDescriptors(key, func() (*descriptorpb.FileDescriptorSet, error) {
+		bb, err := base64.StdEncoding.DecodeString(descriptor)
+		if err != nil {
+			return nil, err
+		}
 
-	fds, err := LoadFileDescriptorSet(bb)
+		// the set is registered as is, so it can import the files of a previous load
+		fdset := &descriptorpb.FileDescriptorSet{}
+		if err = proto.Unmarshal(bb, fdset); err != nil {
+			return nil, err
+		}
+		return fdset, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	fdset := &descriptorpb.FileDescriptorSet{}
-
-	seen := make(map[string]struct{})
-	for _, fd := range fds {
-		fdset.File = append(fdset.File, walkFileDescriptors(seen, fd)...)
-	}
-	return c.convertToMethodInfo(fdset)
+	return c.addDescriptors(parsed)
 }
 
 // LoadFileDescriptorSet 加载pb.bin文件二进制
@@ -152,12 +224,18 @@ func (c *Client) LoadProtoset(protosetPath string) ([]MethodInfo, error) {
 		return nil, fmt.Errorf("couldn't read protoset: %w", err)
 	}
 
-	fdset := &descriptorpb.FileDescriptorSet{}
-	if err = proto.Unmarshal(fdsetBytes, fdset); err != nil {
+	key := descriptorCacheKey("protoset", fdsetBytes)
+	parsed, err := cachedDescriptors(key, func() (*descriptorpb.FileDescriptorSet, error) {
+		fdset := &descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(fdsetBytes, fdset); err != nil {
+			return nil, err
+		}
+		return fdset, nil
+	})
+	if err != nil {
 		return nil, fmt.Errorf("couldn't unmarshal protoset file %s: %w", protosetPath, err)
 	}
-
-	return c.convertToMethodInfo(fdset)
+	return c.addDescriptors(parsed)
 }
 
 // Connect is a block dial to the gRPC server at the given address (host:port)
@@ -172,151 +250,161 @@ func (c *Client) Connect(addr string, params map[string]interface{}) (bool, erro
 	if err != nil {
 		return false, fmt.Errorf("invalid grpc.connect() parameters: %w", err)
 	}
+	if err = c.defaults.apply(p.Defaults); err != nil {
+		return false, fmt.Errorf("invalid grpc.connect() parameters: %w", err)
+	}
 
-	opts := xgrpc_conn.DefaultOptions(c.vu)
-
-	var tcred credentials.TransportCredentials
+	o := xgrpc_conn.Options{
+		Sink:           xgrpc_conn.VUMetricsSink(c.vu),
+		Lazy:           p.Lazy,
+		ServiceConfig:  xgrpc_conn.HealthServiceConfig(p.HealthCheck, p.HealthCheckService),
+		MaxReceiveSize: int(p.MaxReceiveSize),
+		MaxSendSize:    int(p.MaxSendSize),
+	}
 	if !p.IsPlaintext {
 		tlsCfg := state.TLSConfig.Clone()
 		tlsCfg.NextProtos = []string{"h2"}
 
 		// TODO(rogchap): Would be good to add support for custom RootCAs (self signed)
-		tcred = credentials.NewTLS(tlsCfg)
-	} else {
-		tcred = insecure.NewCredentials()
+		o.TLSConfig = tlsCfg
 	}
-	opts = append(opts, grpc.WithTransportCredentials(tcred))
-
 	if ua := state.Options.UserAgent; ua.Valid {
-		opts = append(opts, grpc.WithUserAgent(ua.ValueOrZero()))
+		o.UserAgent = ua.ValueOrZero()
+	}
+	if p.Credentials != nil {
+		source, err := p.Credentials.tokenSource(state)
+		if err != nil {
+			return false, fmt.Errorf("invalid grpc.connect() parameters: %w", err)
+		}
+		o.PerRPCCredentials = xgrpc_conn.PerRPCCredentials(source, p.IsPlaintext)
 	}
+	tracker := c.stateTracker(addr, p.LogStateTransitions)
+	o.Dialer = tracker.Dialer(xgrpc_conn.ProxyDialer(
+		xgrpc_conn.VUProxyConfig(c.vu, p.proxy()), xgrpc_conn.VUDialer(c.vu)))
 
 	ctx, cancel := context.WithTimeout(c.vu.Context(), p.Timeout)
 	defer cancel()
 
-	if p.MaxReceiveSize > 0 {
-		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(int(p.MaxReceiveSize))))
-	}
-
-	if p.MaxSendSize > 0 {
-		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(int(p.MaxSendSize))))
-	}
-
-	opts = append(opts, grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`))
-
 	c.addr = addr
 
-	var conn *xgrpc_conn.Conn = nil
+	var conn *xgrpc_conn.Conn
+	c.pooled = nil
 	if p.ShareConn {
-		if v, ok := connectionPool[addr]; ok {
-			conn = v
-		} else {
-			lck.Lock()
-			if vv, ok1 := connectionPool[addr]; !ok1 {
-				conn, err = xgrpc_conn.Dial(ctx, addr, opts...)
-				if err == nil {
-					connectionPool[addr] = conn
-				}
-			} else {
-				conn = vv
-			}
-			lck.Unlock()
+		// the shared connection outlives the VU, it isn't tracked with its context and tags
+		if tracker.Tags != nil {
+			tracker.Tags = tracker.Tags.Without("scenario").Without("group")
+		}
+		c.pooled, err = pooledConnection(addr, tracker, func() (*xgrpc_conn.Conn, error) {
+			return xgrpc_conn.Connect(ctx, addr, o)
+		})
+		if err == nil {
+			conn = c.pooled.conn
 		}
-
 	} else {
-		conn, err = xgrpc_conn.Dial(ctx, addr, opts...)
+		conn, err = xgrpc_conn.Connect(ctx, addr, o)
+		if err == nil {
+			tracker.Track(c.vu.Context(), conn)
+		}
 	}
 
 	if err != nil {
 		return false, err
 	}
-	c.conn = conn
+	c.base().SetConn(conn)
 
 	if !p.UseReflectionProtocol {
 		return true, nil
 	}
-	fdset, err := c.conn.Reflect(ctx)
-	if err != nil {
+	if _, err = c.reflect(p.Reflect); err != nil {
 		return false, err
 	}
-	_, err = c.convertToMethodInfo(fdset)
-	if err != nil {
-		return false, fmt.Errorf("can't convert method info: %w", err)
-	}
-
-	return true, err
+	return true, nil
 }
 
+// ConnectV1 connects to the gRPC server in plaintext without blocking, nor the VU's dialer and metrics.
+//
+// Deprecated: use Connect, or xgrpc_conn.Client in Go tests.
 func (c *Client) ConnectV1(addr string, params map[string]interface{}) (bool, error) {
-
 	p, err := parseConnectParams(params)
 	if err != nil {
 		return false, fmt.Errorf("invalid grpc.connect() parameters: %w", err)
 	}
 
-	var opts []grpc.DialOption
-	var tcred credentials.TransportCredentials
-
-	tcred = insecure.NewCredentials()
-
-	opts = append(opts, grpc.WithTransportCredentials(tcred))
-
-	ctx, cancel := context.WithTimeout(c.vu.Context(), p.Timeout)
-	defer cancel()
-
-	if p.MaxReceiveSize > 0 {
-		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(int(p.MaxReceiveSize))))
-	}
-
-	if p.MaxSendSize > 0 {
-		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(int(p.MaxSendSize))))
-	}
-
-	opts = append(opts, grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`))
-
-	c.addr = addr
-	c.conn, err = xgrpc_conn.Dial(ctx, addr, opts...)
+	c.addr, c.pooled = addr, nil
+	conn, err := xgrpc_conn.Connect(c.vu.Context(), addr, xgrpc_conn.Options{
+		Lazy:           true,
+		ServiceConfig:  xgrpc_conn.HealthServiceConfig(p.HealthCheck, p.HealthCheckService),
+		MaxReceiveSize: int(p.MaxReceiveSize),
+		MaxSendSize:    int(p.MaxSendSize),
+	})
 	if err != nil {
 		return false, err
 	}
+	c.base().SetConn(conn)
 
 	if !p.UseReflectionProtocol {
 		return true, nil
 	}
-	fdset, err := c.conn.Reflect(ctx)
-	if err != nil {
+	if _, err = c.reflect(p.Reflect); err != nil {
 		return false, err
 	}
-	_, err = c.convertToMethodInfo(fdset)
-	if err != nil {
-		return false, fmt.Errorf("can't convert method info: %w", err)
-	}
+	return true, nil
+}
 
-	return true, err
+// stateTracker returns the tracker of the connectivity state transitions of the connection to addr.
+func (c *Client) stateTracker(addr string, logTransitions bool) *xgrpc_conn.StateTracker {
+	state := c.vu.State()
+	tracker := &xgrpc_conn.StateTracker{
+		Target: addr,
+		Metrics: xgrpc_conn.StateMetrics{
+			Transitions: c.metrics.connStateTransitions,
+			TimeInState: c.metrics.connStateDuration,
+		},
+		Samples: state.Samples,
+		Tags:    state.Tags.GetCurrentValues().Tags,
+	}
+	if logTransitions {
+		tracker.Logger = state.Logger.WithField("source", "grpc")
+	}
+	return tracker
 }
 
-// Invoke creates and calls a unary RPC by fully qualified method name
+// Invoke creates and calls a unary RPC by fully qualified method name,
+// or by a Template in which case req holds the template variables.
 func (c *Client) Invoke(
-	method string,
+	methodVal sobek.Value,
 	req sobek.Value,
 	params sobek.Value,
-) (*xgrpc_conn.Response, error) {
+) (*Response, error) {
 	state := c.vu.State()
 	if state == nil {
 		return nil, common.NewInitContextError("invoking RPC methods in the init context is not supported")
 	}
-	if c.conn == nil {
+	if c.base().Conn() == nil {
 		return nil, errors.New("no gRPC connection, you must call connect first")
 	}
+
+	var tpl *Template
+	var method string
+	if methodVal != nil && !sobek.IsUndefined(methodVal) && !sobek.IsNull(methodVal) {
+		if t, ok := methodVal.Export().(*Template); ok {
+			tpl, method = t, t.method
+		} else {
+			method = methodVal.String()
+		}
+	}
 	if method == "" {
 		return nil, errors.New("method to invoke cannot be empty")
 	}
 	if method[0] != '/' {
 		method = "/" + method
 	}
-	methodDesc := c.mds[method]
-	if methodDesc == nil {
-		return nil, fmt.Errorf("method %q not found in file descriptors", method)
+	methodDesc, err := c.base().Method(method)
+	if tpl != nil {
+		methodDesc, err = tpl.md, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	p, err := c.parseInvokeParams(params)
@@ -327,16 +415,28 @@ func (c *Client) Invoke(
 	if req == nil {
 		return nil, errors.New("request cannot be nil")
 	}
-	b, err := req.ToObject(c.vu.Runtime()).MarshalJSON()
+	reqmsg := xgrpc_conn.Request{
+		MethodDescriptor:       methodDesc,
+		TagsAndMeta:            &p.TagsAndMeta,
+		DiscardResponseMessage: p.DiscardResponseMessage,
+		ProtoResponse:          p.TypeMapping != nil,
+		JSONOptions:            &p.JSONOptions,
+		Types:                  c.types(),
+		Signer:                 p.Signer,
+	}
+	var violations []violation
+	if tpl != nil {
+		reqmsg.ProtoMessage, err = c.buildTemplate(tpl, req)
+		if err == nil && p.Validate {
+			violations = validateConstraints(reqmsg.ProtoMessage.ProtoReflect(), c.types(), &c.constraints, p.JSONOptions.UseProtoNames)
+		}
+	} else {
+		violations, err = c.encodeRequest(req, p, &reqmsg)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to serialise request object: %w", err)
 	}
 
-	md := metadata.New(nil)
-	for param, strval := range p.Metadata {
-		md.Append(param, strval)
-	}
-
 	ctx, cancel := context.WithTimeout(c.vu.Context(), p.Timeout)
 	defer cancel()
 
@@ -352,26 +452,197 @@ func (c *Client) Invoke(
 		p.TagsAndMeta.SetSystemTagOrMetaIfEnabled(state.Options.SystemTags, metrics.TagName, method)
 	}
 
-	reqmsg := xgrpc_conn.Request{
-		MethodDescriptor: methodDesc,
-		Message:          b,
-		TagsAndMeta:      &p.TagsAndMeta,
+	if len(violations) > 0 {
+		return c.rejectRequest(ctx, p, violations), nil
+	}
+
+	client, err := c.base().WithAuthority(p.Authority)
+	if err != nil {
+		return nil, err
+	}
+	reqmsg.DiscardResponseMessage = reqmsg.DiscardResponseMessage || state.Options.DiscardResponseBodies.Bool
+	r, err := client.InvokeRequest(ctx, method, p.Metadata, reqmsg, p.callOptions()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.newResponse(r, p), nil
+}
+
+// buildTemplate returns the template's message with the placeholders replaced
+// by the given variables.
+func (c *Client) buildTemplate(tpl *Template, vars sobek.Value) (proto.Message, error) {
+	if sobek.IsUndefined(vars) || sobek.IsNull(vars) {
+		return tpl.build(nil)
+	}
+	rawVars, ok := vars.Export().(map[string]interface{})
+	if !ok {
+		return nil, errors.New("template variables must be an object with key-value pairs")
+	}
+	return tpl.build(rawVars)
+}
+
+// encodeRequest sets the request message: an ArrayBuffer or a typed array is an already
+// serialized protobuf message, a string is in the prototext format and any other object
+// is serialised to JSON, or directly converted to a protobuf message with a type mapping.
+// With the validate param, an object is always converted and the violations of its fields
+// are returned instead of an error. An already encoded request is sent as is: the type
+// mapping only maps its response, and with validate it is decoded to check its constraints.
+func (c *Client) encodeRequest(req sobek.Value, p *invokeParams, reqmsg *xgrpc_conn.Request) ([]violation, error) {
+	rt := c.vu.Runtime()
+	switch req.ExportType() {
+	case reflect.TypeOf(""):
+		reqmsg.Message, reqmsg.Format = []byte(req.String()), xgrpc_conn.MessageFormatText
+		return c.validateEncoded(p, reqmsg)
+	case reflect.TypeOf(sobek.ArrayBuffer{}), reflect.TypeOf([]byte(nil)):
+		var b []byte
+		if err := rt.ExportTo(req, &b); err != nil {
+			return nil, err
+		}
+		reqmsg.Message, reqmsg.Format = b, xgrpc_conn.MessageFormatBinary
+		return c.validateEncoded(p, reqmsg)
+	}
+
+	if p.Validate {
+		violations := []violation{}
+		conv := c.converter(p)
+		conv.violations = &violations
+		msg, err := conv.messageFromJS(reqmsg.MethodDescriptor.Input(), req)
+		if err != nil {
+			return nil, err
+		}
+		reqmsg.ProtoMessage = msg
+		return append(violations, validateConstraints(msg, c.types(), &c.constraints, p.JSONOptions.UseProtoNames)...), nil
+	}
+
+	if p.TypeMapping != nil {
+		msg, err := c.converter(p).messageFromJS(reqmsg.MethodDescriptor.Input(), req)
+		if err != nil {
+			return nil, err
+		}
+		reqmsg.ProtoMessage = msg
+		return nil, nil
+	}
+
+	b, err := req.ToObject(rt).MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	reqmsg.Message, reqmsg.Format = b, xgrpc_conn.MessageFormatJSON
+	return nil, nil
+}
+
+// validateEncoded returns the violations of the constraints of an already encoded request
+// with the validate param, the request is still sent as it was encoded.
+func (c *Client) validateEncoded(p *invokeParams, reqmsg *xgrpc_conn.Request) ([]violation, error) {
+	if !p.Validate {
+		return nil, nil
+	}
+	msg := dynamicpb.NewMessage(reqmsg.MethodDescriptor.Input())
+	var err error
+	if reqmsg.Format == xgrpc_conn.MessageFormatText {
+		err = prototext.UnmarshalOptions{Resolver: c.types(), DiscardUnknown: p.JSONOptions.DiscardUnknown}.
+			Unmarshal(reqmsg.Message, msg)
+	} else {
+		err = proto.UnmarshalOptions{Resolver: c.types()}.Unmarshal(reqmsg.Message, msg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the request to validate it: %w", err)
+	}
+	return validateConstraints(msg, c.types(), &c.constraints, p.JSONOptions.UseProtoNames), nil
+}
+
+// rejectRequest returns the response of a request that failed the validation, it is not sent
+// and counted by the grpc_req_invalid metric.
+func (c *Client) rejectRequest(ctx context.Context, p *invokeParams, violations []violation) *Response {
+	if c.metrics.reqInvalid != nil {
+		metrics.PushIfNotDone(ctx, c.vu.State().Samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{Metric: c.metrics.reqInvalid, Tags: p.TagsAndMeta.Tags},
+			Time:       time.Now(),
+			Metadata:   p.TagsAndMeta.Metadata,
+			Value:      1,
+		})
+	}
+	return &Response{
+		Status:   codes.InvalidArgument,
+		Error:    invalidRequestError(violations),
+		Headers:  map[string]interface{}{},
+		Trailers: map[string]interface{}{},
+	}
+}
+
+// Response represents a gRPC response as exposed to the JS runtime.
+type Response struct {
+	Message  interface{}
+	Error    interface{}
+	Headers  map[string]interface{}
+	Trailers map[string]interface{}
+	Status   codes.Code
+	Duration *float64
+}
+
+// converter returns the converter configured by the invoke params.
+func (c *Client) converter(p *invokeParams) jsConverter {
+	conv := jsConverter{rt: c.vu.Runtime(), json: p.JSONOptions, types: c.types()}
+	if p.TypeMapping != nil {
+		conv.mapping = *p.TypeMapping
 	}
+	return conv
+}
 
-	r, e := c.conn.Invoke(ctx, state.Options, method, md, reqmsg)
+func (c *Client) newResponse(r *xgrpc_conn.Response, p *invokeParams) *Response {
+	resp := &Response{
+		Message:  r.Message,
+		Error:    r.Error,
+		Headers:  c.metadataToJS(r.Headers),
+		Trailers: c.metadataToJS(r.Trailers),
+		Status:   r.Status,
+		Duration: r.Duration,
+	}
+	if p.TypeMapping != nil && r.ProtoMessage != nil {
+		resp.Message = c.converter(p).messageToJS(r.ProtoMessage.ProtoReflect())
+	}
+	return resp
+}
 
-	return r, e
+// metadataToJS converts the received metadata into JS values, binary ("-bin") keys
+// are already base64-decoded by grpc so their values are exposed as ArrayBuffers.
+func (c *Client) metadataToJS(md map[string][]string) map[string]interface{} {
+	rt := c.vu.Runtime()
+	result := make(map[string]interface{}, len(md))
+	for k, vals := range md {
+		if !strings.HasSuffix(k, binMetadataSuffix) {
+			result[k] = vals
+			continue
+		}
+		bufs := make([]interface{}, 0, len(vals))
+		for _, v := range vals {
+			bufs = append(bufs, rt.NewArrayBuffer([]byte(v)))
+		}
+		result[k] = bufs
+	}
+	return result
+}
+
+// SetDefaults sets the params used by every invoke call of the client: metadata, timeout,
+// tags, discardResponseMessage, typeMapping, validate, waitForReady, signing and the JSON options
+// (useProtoNames, useEnumNumbers, emitUnpopulated and discardUnknown).
+// Params passed to invoke take precedence.
+func (c *Client) SetDefaults(params map[string]interface{}) error {
+	if err := c.defaults.apply(params); err != nil {
+		return fmt.Errorf("invalid grpc.setDefaults() parameters: %w", err)
+	}
+	return nil
 }
 
 // Close will close the client gRPC connection
 func (c *Client) Close() error {
-	if c.conn == nil {
-		return nil
+	if pooled := c.pooled; pooled != nil {
+		c.pooled = nil
+		c.base().SetConn(nil)
+		return pooled.close(c.addr)
 	}
-	err := c.conn.Close()
-	c.conn = nil
-
-	return err
+	return c.base().Close()
 }
 
 // MethodInfo holds information on any parsed method descriptors that can be used by the  VM
@@ -383,73 +654,209 @@ type MethodInfo struct {
 }
 
 func (c *Client) convertToMethodInfo(fdset *descriptorpb.FileDescriptorSet) ([]MethodInfo, error) {
-	files, err := protodesc.NewFiles(fdset)
+	// The files are registered in the client's registry, so the same message
+	// loaded with different definitions by two clients doesn't collide.
+	files, err := c.base().Registry().Register(fdset)
 	if err != nil {
 		return nil, err
 	}
-	var rtn []MethodInfo
-	if c.mds == nil {
-		// This allows us to call load() multiple times, without overwriting the
-		// previously loaded definitions.
-		c.mds = make(map[string]protoreflect.MethodDescriptor)
-	}
-	appendMethodInfo := func(
-		fd protoreflect.FileDescriptor,
-		sd protoreflect.ServiceDescriptor,
-		md protoreflect.MethodDescriptor,
-	) {
-		name := fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())
-		c.mds[name] = md
-		rtn = append(rtn, MethodInfo{
-			MethodInfo: grpc.MethodInfo{
-				Name:           string(md.Name()),
-				IsClientStream: md.IsStreamingClient(),
-				IsServerStream: md.IsStreamingServer(),
-			},
-			Package:    string(fd.Package()),
-			Service:    string(sd.Name()),
-			FullMethod: name,
-		})
+	// This allows us to call load() multiple times, without overwriting the
+	// previously loaded definitions.
+	if _, err = c.base().LoadFiles(files); err != nil {
+		return nil, err
 	}
-	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+	methods := collectMethods(files)
+	return methods, nil
+}
+
+// collectMethods returns the methods of the services declared in the files.
+func collectMethods(files []protoreflect.FileDescriptor) []MethodInfo {
+	var rtn []MethodInfo
+	for _, fd := range files {
 		sds := fd.Services()
 		for i := 0; i < sds.Len(); i++ {
 			sd := sds.Get(i)
-			mds := sd.Methods()
-			for j := 0; j < mds.Len(); j++ {
-				md := mds.Get(j)
-				appendMethodInfo(fd, sd, md)
+			methods := sd.Methods()
+			for j := 0; j < methods.Len(); j++ {
+				md := methods.Get(j)
+				name := fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())
+				rtn = append(rtn, MethodInfo{
+					MethodInfo: grpc.MethodInfo{
+						Name:           string(md.Name()),
+						IsClientStream: md.IsStreamingClient(),
+						IsServerStream: md.IsStreamingServer(),
+					},
+					Package:    string(fd.Package()),
+					Service:    string(sd.Name()),
+					FullMethod: name,
+				})
 			}
 		}
-		messages := fd.Messages()
-		for i := 0; i < messages.Len(); i++ {
-			message := messages.Get(i)
-			_, errFind := protoregistry.GlobalTypes.FindMessageByName(message.FullName())
-			if errors.Is(errFind, protoregistry.NotFound) {
-				err = protoregistry.GlobalTypes.RegisterMessage(dynamicpb.NewMessageType(message))
-				if err != nil {
-					return false
+	}
+	return rtn
+}
+
+// base returns the Go client the JS client is built on, it holds the loaded descriptors
+// and the connection.
+func (c *Client) base() *xgrpc_conn.Client {
+	if c.client == nil {
+		c.client = xgrpc_conn.NewClientWithConn(nil)
+	}
+	return c.client
+}
+
+// types returns the resolver of the loaded message types.
+func (c *Client) types() xgrpc_conn.TypeResolver {
+	return c.base().Registry()
+}
+
+const defaultInvokeTimeout = time.Minute
+
+// invokeDefaults holds the client-level params merged into every invoke call.
+type invokeDefaults struct {
+	Metadata               metadata.MD
+	Timeout                time.Duration
+	Tags                   map[string]string
+	DiscardResponseMessage bool
+	TypeMapping            *typeMapping
+	JSONOptions            xgrpc_conn.JSONOptions
+	Validate               bool
+	WaitForReady           bool
+	Signer                 *xgrpc_conn.Signer
+}
+
+// apply updates the defaults with the given exported JS params,
+// keys not present in raw keep their current value.
+func (d *invokeDefaults) apply(raw map[string]interface{}) error {
+	for k, v := range raw {
+		switch k {
+		case "metadata":
+			rawHeaders, ok := v.(map[string]interface{})
+			if !ok {
+				return errors.New("metadata must be an object with key-value pairs")
+			}
+			md, err := parseMetadata(rawHeaders)
+			if err != nil {
+				return err
+			}
+			d.Metadata = md
+		case "timeout":
+			var err error
+			d.Timeout, err = types.GetDurationValue(v)
+			if err != nil {
+				return fmt.Errorf("invalid timeout value: %w", err)
+			}
+		case "tags":
+			rawTags, ok := v.(map[string]interface{})
+			if !ok {
+				return errors.New("tags must be an object with key-value pairs")
+			}
+			d.Tags = make(map[string]string, len(rawTags))
+			for tk, tv := range rawTags {
+				switch tv.(type) {
+				case string, bool, int64, float64:
+					d.Tags[tk] = fmt.Sprint(tv)
+				default:
+					return fmt.Errorf("invalid value for metric tag '%s': "+
+						"only String, Boolean and Number types are accepted as a metric tag values", tk)
 				}
 			}
+		case "discardResponseMessage":
+			var ok bool
+			d.DiscardResponseMessage, ok = v.(bool)
+			if !ok {
+				return fmt.Errorf("invalid discardResponseMessage value: '%#v', it needs to be boolean", v)
+			}
+		case "typeMapping":
+			mapping, err := parseTypeMapping(v)
+			if err != nil {
+				return err
+			}
+			d.TypeMapping = mapping
+		case "useProtoNames", "useEnumNumbers", "emitUnpopulated", "discardUnknown":
+			if err := setJSONOption(&d.JSONOptions, k, v); err != nil {
+				return err
+			}
+		case "validate":
+			var ok bool
+			d.Validate, ok = v.(bool)
+			if !ok {
+				return fmt.Errorf("invalid validate value: '%#v', it needs to be boolean", v)
+			}
+		case "waitForReady":
+			var ok bool
+			d.WaitForReady, ok = v.(bool)
+			if !ok {
+				return fmt.Errorf("invalid waitForReady value: '%#v', it needs to be boolean", v)
+			}
+		case "signing":
+			signer, err := parseSigningParams(v)
+			if err != nil {
+				return err
+			}
+			d.Signer = signer
+		default:
+			return fmt.Errorf("unknown param: %q", k)
 		}
-		return true
-	})
-	if err != nil {
-		return nil, err
 	}
-	return rtn, nil
+	return nil
 }
 
 type invokeParams struct {
-	Metadata    map[string]string
-	TagsAndMeta metrics.TagsAndMeta
-	Timeout     time.Duration
+	Metadata               metadata.MD
+	TagsAndMeta            metrics.TagsAndMeta
+	Timeout                time.Duration
+	DiscardResponseMessage bool
+	TypeMapping            *typeMapping
+	JSONOptions            xgrpc_conn.JSONOptions
+	Validate               bool
+	// WaitForReady blocks the call until the connection is ready, instead of failing fast.
+	WaitForReady bool
+	// Signer signs the serialized request into the metadata, when set.
+	Signer *xgrpc_conn.Signer
+	// Authority replaces the :authority header, the target's host by default. Each authority
+	// opens its own connection to the target, kept until close, at most xgrpc_conn.MaxAuthorities.
+	Authority      string
+	MaxReceiveSize int
+	MaxSendSize    int
+	ContentSubtype string
+}
+
+// callOptions returns the gRPC call options of the params.
+func (p *invokeParams) callOptions() []grpc.CallOption {
+	var opts []grpc.CallOption
+	if p.WaitForReady {
+		opts = append(opts, grpc.WaitForReady(true))
+	}
+	if p.MaxReceiveSize > 0 {
+		opts = append(opts, grpc.MaxCallRecvMsgSize(p.MaxReceiveSize))
+	}
+	if p.MaxSendSize > 0 {
+		opts = append(opts, grpc.MaxCallSendMsgSize(p.MaxSendSize))
+	}
+	if p.ContentSubtype != "" {
+		opts = append(opts, grpc.CallContentSubtype(p.ContentSubtype))
+	}
+	return opts
 }
 
 func (c *Client) parseInvokeParams(paramsVal sobek.Value) (*invokeParams, error) {
 	result := &invokeParams{
-		Timeout:     1 * time.Minute,
-		TagsAndMeta: c.vu.State().Tags.GetCurrentValues(),
+		Metadata:               c.defaults.Metadata.Copy(),
+		Timeout:                c.defaults.Timeout,
+		TagsAndMeta:            c.vu.State().Tags.GetCurrentValues(),
+		DiscardResponseMessage: c.defaults.DiscardResponseMessage,
+		TypeMapping:            c.defaults.TypeMapping,
+		JSONOptions:            c.defaults.JSONOptions,
+		Validate:               c.defaults.Validate,
+		WaitForReady:           c.defaults.WaitForReady,
+		Signer:                 c.defaults.Signer,
+	}
+	if result.Timeout == 0 {
+		result.Timeout = defaultInvokeTimeout
+	}
+	for k, v := range c.defaults.Tags {
+		result.TagsAndMeta.SetTag(k, v)
 	}
 	if paramsVal == nil || sobek.IsUndefined(paramsVal) || sobek.IsNull(paramsVal) {
 		return result, nil
@@ -462,19 +869,18 @@ func (c *Client) parseInvokeParams(paramsVal sobek.Value) (*invokeParams, error)
 			c.vu.State().Logger.Warn("The headers property is deprecated, replace it with the metadata property, please.")
 			fallthrough
 		case "metadata":
-			result.Metadata = make(map[string]string)
 			v := params.Get(k).Export()
 			rawHeaders, ok := v.(map[string]interface{})
 			if !ok {
 				return result, errors.New("metadata must be an object with key-value pairs")
 			}
-			for hk, kv := range rawHeaders {
-				// TODO(rogchap): Should we manage a string slice?
-				strval, ok := kv.(string)
-				if !ok {
-					return result, fmt.Errorf("metadata %q value must be a string", hk)
-				}
-				result.Metadata[hk] = strval
+			md, err := parseMetadata(rawHeaders)
+			if err != nil {
+				return result, err
+			}
+			// per-call values replace the defaults of the same key
+			for mk, mv := range md {
+				result.Metadata[mk] = mv
 			}
 		case "tags":
 			if err := common.ApplyCustomUserTags(rt, &result.TagsAndMeta, params.Get(k)); err != nil {
@@ -487,20 +893,176 @@ func (c *Client) parseInvokeParams(paramsVal sobek.Value) (*invokeParams, error)
 			if err != nil {
 				return result, fmt.Errorf("invalid timeout value: %w", err)
 			}
+		case "discardResponseMessage":
+			var ok bool
+			result.DiscardResponseMessage, ok = params.Get(k).Export().(bool)
+			if !ok {
+				return result, errors.New("discardResponseMessage must be a boolean")
+			}
+		case "typeMapping":
+			mapping, err := parseTypeMapping(params.Get(k).Export())
+			if err != nil {
+				return result, err
+			}
+			result.TypeMapping = mapping
+		case "useProtoNames", "useEnumNumbers", "emitUnpopulated", "discardUnknown":
+			if err := setJSONOption(&result.JSONOptions, k, params.Get(k).Export()); err != nil {
+				return result, err
+			}
+		case "validate":
+			var ok bool
+			result.Validate, ok = params.Get(k).Export().(bool)
+			if !ok {
+				return result, errors.New("validate must be a boolean")
+			}
+		case "waitForReady":
+			var ok bool
+			result.WaitForReady, ok = params.Get(k).Export().(bool)
+			if !ok {
+				return result, errors.New("waitForReady must be a boolean")
+			}
+		case "signing":
+			var err error
+			result.Signer, err = parseSigningParams(params.Get(k).Export())
+			if err != nil {
+				return result, err
+			}
+		case "authority":
+			var ok bool
+			result.Authority, ok = params.Get(k).Export().(string)
+			if !ok || result.Authority == "" {
+				return result, errors.New("authority must be a non-empty string")
+			}
+		case "maxReceiveSize", "maxSendSize":
+			n, ok := params.Get(k).Export().(int64)
+			if !ok || n <= 0 {
+				return result, fmt.Errorf("invalid %s value: '%#v', it needs to be a positive integer", k, params.Get(k).Export())
+			}
+			if k == "maxReceiveSize" {
+				result.MaxReceiveSize = int(n)
+			} else {
+				result.MaxSendSize = int(n)
+			}
+		case "contentSubtype":
+			subtype, ok := params.Get(k).Export().(string)
+			if !ok {
+				return result, errors.New("contentSubtype must be a string")
+			}
+			// the codecs are registered by content subtype, in lowercase
+			subtype = strings.ToLower(subtype)
+			if encoding.GetCodecV2(subtype) == nil {
+				return result, fmt.Errorf("no codec registered for the content subtype %q", subtype)
+			}
+			result.ContentSubtype = subtype
 		default:
 			return result, fmt.Errorf("unknown param: %q", k)
 		}
 	}
+	if result.Signer != nil && result.ContentSubtype != "" && result.ContentSubtype != "proto" {
+		return result, fmt.Errorf("a signed request is sent as protobuf, it can't use the content subtype %q",
+			result.ContentSubtype)
+	}
 	return result, nil
 }
 
+// setJSONOption sets the protojson option of the given param key.
+func setJSONOption(opts *xgrpc_conn.JSONOptions, key string, v interface{}) error {
+	b, ok := v.(bool)
+	if !ok {
+		return fmt.Errorf("invalid %s value: '%#v', it needs to be boolean", key, v)
+	}
+	switch key {
+	case "useProtoNames":
+		opts.UseProtoNames = b
+	case "useEnumNumbers":
+		opts.UseEnumNumbers = b
+	case "emitUnpopulated":
+		opts.EmitUnpopulated = b
+	case "discardUnknown":
+		opts.DiscardUnknown = b
+	}
+	return nil
+}
+
+// binMetadataSuffix marks metadata keys carrying binary values.
+const binMetadataSuffix = "-bin"
+
+// parseMetadata converts the exported JS metadata object into gRPC metadata.
+// Values can be a string or an array of strings for repeated keys, binary
+// ("-bin") keys also accept ArrayBuffer and typed array values.
+func parseMetadata(raw map[string]interface{}) (metadata.MD, error) {
+	md := metadata.New(nil)
+	for k, v := range raw {
+		isBin := strings.HasSuffix(strings.ToLower(k), binMetadataSuffix)
+		vals, ok := v.([]interface{})
+		if !ok {
+			vals = []interface{}{v}
+		}
+		for _, val := range vals {
+			strval, err := metadataValue(val, isBin)
+			if err != nil {
+				return nil, fmt.Errorf("metadata %q: %w", k, err)
+			}
+			md.Append(k, strval)
+		}
+	}
+	return md, nil
+}
+
+func metadataValue(v interface{}, isBin bool) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case sobek.ArrayBuffer:
+		if isBin {
+			return string(val.Bytes()), nil
+		}
+	case []byte:
+		if isBin {
+			return string(val), nil
+		}
+	}
+	if isBin {
+		return "", errors.New("value must be a string, an ArrayBuffer or a typed array")
+	}
+	return "", errors.New("value must be a string or an array of strings")
+}
+
 type connectParams struct {
 	IsPlaintext           bool
 	UseReflectionProtocol bool
+	Reflect               reflectParams
 	Timeout               time.Duration
 	MaxReceiveSize        int64
 	MaxSendSize           int64
 	ShareConn             bool
+	// Lazy creates the connection without connecting, it connects on the first request.
+	Lazy bool
+	// LogStateTransitions logs the connectivity state transitions.
+	LogStateTransitions bool
+	// HealthCheck enables the client-side health checking of HealthCheckService.
+	HealthCheck        bool
+	HealthCheckService string
+	// Credentials authenticate the RPCs, when set.
+	Credentials *credentialsParams
+	// Proxy tunnels the connections, the proxy of the environment is used when nil
+	// unless DisableProxy is set.
+	Proxy        *url.URL
+	DisableProxy bool
+	// Defaults holds the invoke defaults given on connect,
+	// "timeout" is not part of them as it is the dial timeout.
+	Defaults map[string]interface{}
+}
+
+// proxy returns the proxy of the connection, the proxy itself is dialed with the VU's dialer.
+func (p connectParams) proxy() xgrpc_conn.ProxyFunc {
+	switch {
+	case p.Proxy != nil:
+		return xgrpc_conn.FixedProxy(p.Proxy)
+	case p.DisableProxy:
+		return xgrpc_conn.FixedProxy(nil)
+	}
+	return xgrpc_conn.ProxyFromEnvironment()
 }
 
 func parseConnectParams(raw map[string]interface{}) (connectParams, error) {
@@ -511,6 +1073,7 @@ func parseConnectParams(raw map[string]interface{}) (connectParams, error) {
 		MaxReceiveSize:        0,
 		MaxSendSize:           0,
 		ShareConn:             false,
+		Defaults:              make(map[string]interface{}),
 	}
 	for k, v := range raw {
 		switch k {
@@ -520,6 +1083,18 @@ func parseConnectParams(raw map[string]interface{}) (connectParams, error) {
 			if !ok {
 				return params, fmt.Errorf("invalid plaintext value: '%#v', it needs to be boolean", v)
 			}
+		case "lazy":
+			var ok bool
+			params.Lazy, ok = v.(bool)
+			if !ok {
+				return params, fmt.Errorf("invalid lazy value: '%#v', it needs to be boolean", v)
+			}
+		case "logStateTransitions":
+			var ok bool
+			params.LogStateTransitions, ok = v.(bool)
+			if !ok {
+				return params, fmt.Errorf("invalid logStateTransitions value: '%#v', it needs to be boolean", v)
+			}
 		case "shareConn":
 			var ok bool
 			params.ShareConn, ok = v.(bool)
@@ -533,10 +1108,45 @@ func parseConnectParams(raw map[string]interface{}) (connectParams, error) {
 				return params, fmt.Errorf("invalid timeout value: %w", err)
 			}
 		case "reflect":
-			var ok bool
-			params.UseReflectionProtocol, ok = v.(bool)
-			if !ok {
-				return params, fmt.Errorf("invalid reflect value: '%#v', it needs to be boolean", v)
+			switch val := v.(type) {
+			case bool:
+				params.UseReflectionProtocol = val
+			case map[string]interface{}:
+				var err error
+				params.UseReflectionProtocol = true
+				params.Reflect, err = parseReflectParams(val)
+				if err != nil {
+					return params, fmt.Errorf("invalid reflect value: %w", err)
+				}
+			default:
+				return params, fmt.Errorf("invalid reflect value: '%#v', it needs to be boolean or an object", v)
+			}
+		case "healthCheck":
+			switch val := v.(type) {
+			case bool:
+				params.HealthCheck = val
+			case string:
+				params.HealthCheck, params.HealthCheckService = true, val
+			default:
+				return params, fmt.Errorf("invalid healthCheck value: '%#v', it needs to be boolean or a service name", v)
+			}
+		case "credentials":
+			var err error
+			params.Credentials, err = parseCredentialsParams(v)
+			if err != nil {
+				return params, err
+			}
+		case "proxy":
+			switch val := v.(type) {
+			case bool:
+				params.DisableProxy = !val
+			case string:
+				var err error
+				if params.Proxy, err = xgrpc_conn.ParseProxyURL(val); err != nil {
+					return params, err
+				}
+			default:
+				return params, fmt.Errorf("invalid proxy value: '%#v', it needs to be a URL or boolean", v)
 			}
 		case "maxReceiveSize":
 			var ok bool
@@ -556,27 +1166,15 @@ func parseConnectParams(raw map[string]interface{}) (connectParams, error) {
 			if params.MaxSendSize < 0 {
 				return params, fmt.Errorf("invalid maxSendSize value: '%#v, it needs to be a positive integer", v)
 			}
-
+		case "metadata", "tags", "discardResponseMessage", "typeMapping", "validate", "waitForReady", "signing",
+			"useProtoNames", "useEnumNumbers", "emitUnpopulated", "discardUnknown":
+			params.Defaults[k] = v
 		default:
 			return params, fmt.Errorf("unknown connect param: %q", k)
 		}
 	}
-	return params, nil
-}
-
-func walkFileDescriptors(seen map[string]struct{}, fd *desc.FileDescriptor) []*descriptorpb.FileDescriptorProto {
-	fds := []*descriptorpb.FileDescriptorProto{}
-
-	if _, ok := seen[fd.GetName()]; ok {
-		return fds
+	if params.Reflect.Timeout == 0 {
+		params.Reflect.Timeout = params.Timeout
 	}
-	seen[fd.GetName()] = struct{}{}
-	fds = append(fds, fd.AsFileDescriptorProto())
-
-	for _, dep := range fd.GetDependencies() {
-		deps := walkFileDescriptors(seen, dep)
-		fds = append(fds, deps...)
-	}
-
-	return fds
+	return params, nil
 }
@@ -6,10 +6,18 @@ import (
 	"go.k6.io/k6/js/modulestest"
 	"runtime"
 	"testing"
+	"time"
 
+	"github.com/grafana/sobek"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/shlsky/xk6-grpc/xgrpc_conn"
 	xk6_nacos "github.com/shlsky/xk6-nacos"
+	"go.k6.io/k6/lib"
 	"go.k6.io/k6/lib/testutils/httpmultibin"
 	"go.k6.io/k6/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 const isWindows = runtime.GOOS == "windows"
@@ -30,6 +38,19 @@ type testcase struct {
 	vuString   codeBlock // runs in the vu context
 }
 
+// parseTestProto parses the proto source and returns its file descriptor.
+func parseTestProto(t *testing.T, src string) protoreflect.FileDescriptor {
+	t.Helper()
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"test.proto": src}),
+	}
+	fds, err := parser.ParseFiles("test.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fds[0].UnwrapFile()
+}
+
 func TestUtil(t *testing.T) {
 	u := Util{}
 	for i := 0; i < 100; i++ {
@@ -57,3 +78,176 @@ func TestGrpcNacos(t *testing.T) {
 	}
 	//grpcClient.conn.Invoke(context.Background(), lib.Options{DiscardResponseBodies: null.BoolFrom(false)}, "hello.Hello/SayHello", nil, nil, nil)
 }
+
+func TestParseMetadata(t *testing.T) {
+	rt := sobek.New()
+	v, err := rt.RunString(`({
+		"x-single": "a",
+		"x-multi": ["b", "c"],
+		"ticket-bin": new Uint8Array([0, 1, 255]).buffer,
+		"trace-bin": [new Uint8Array([7]), "raw"],
+	})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := parseMetadata(v.Export().(map[string]interface{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := md.Get("x-single"); len(got) != 1 || got[0] != "a" {
+		t.Errorf("x-single: unexpected %q", got)
+	}
+	if got := md.Get("x-multi"); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("x-multi: unexpected %q", got)
+	}
+	if got := md.Get("ticket-bin"); len(got) != 1 || got[0] != "\x00\x01\xff" {
+		t.Errorf("ticket-bin: unexpected %q", got)
+	}
+	if got := md.Get("trace-bin"); len(got) != 2 || got[0] != "\x07" || got[1] != "raw" {
+		t.Errorf("trace-bin: unexpected %q", got)
+	}
+
+	v, err = rt.RunString(`({"x-plain": new Uint8Array([1]).buffer})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = parseMetadata(v.Export().(map[string]interface{})); err == nil {
+		t.Error("expected an error for a binary value on a non-binary key")
+	}
+}
+
+func TestInvokeDefaultsApply(t *testing.T) {
+	var d invokeDefaults
+	err := d.apply(map[string]interface{}{
+		"metadata":               map[string]interface{}{"x-env": "test"},
+		"timeout":                "5s",
+		"tags":                   map[string]interface{}{"suite": "smoke", "shard": int64(2)},
+		"discardResponseMessage": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Timeout != 5*time.Second || !d.DiscardResponseMessage {
+		t.Errorf("unexpected defaults %+v", d)
+	}
+	if d.Tags["suite"] != "smoke" || d.Tags["shard"] != "2" {
+		t.Errorf("unexpected tags %v", d.Tags)
+	}
+	if got := d.Metadata.Get("x-env"); len(got) != 1 || got[0] != "test" {
+		t.Errorf("unexpected metadata %v", d.Metadata)
+	}
+
+	if err = d.apply(map[string]interface{}{"timeout": "1m"}); err != nil {
+		t.Fatal(err)
+	}
+	if d.Timeout != time.Minute || d.Tags["suite"] != "smoke" {
+		t.Errorf("keys not given must keep their value, got %+v", d)
+	}
+
+	if err = d.apply(map[string]interface{}{"unknown": 1}); err == nil {
+		t.Error("expected an error for an unknown param")
+	}
+}
+
+func TestInvokeCallOptions(t *testing.T) {
+	t.Parallel()
+
+	rt := sobek.New()
+	c := &Client{vu: &modulestest.VU{
+		RuntimeField: rt,
+		StateField:   &lib.State{Tags: lib.NewVUStateTags(metrics.NewRegistry().RootTagSet())},
+	}}
+	parse := func(js string) (*invokeParams, error) {
+		v, err := rt.RunString(js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.parseInvokeParams(v)
+	}
+
+	p, err := parse(`({authority: "api.example.com", maxReceiveSize: 1024, maxSendSize: 2048, contentSubtype: "PROTO", waitForReady: true})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Authority != "api.example.com" || p.MaxReceiveSize != 1024 || p.MaxSendSize != 2048 || p.ContentSubtype != "proto" {
+		t.Fatalf("unexpected params %+v", p)
+	}
+	if opts := p.callOptions(); len(opts) != 4 {
+		t.Fatalf("expected 4 call options, got %d", len(opts))
+	}
+
+	for _, js := range []string{
+		`({authority: ""})`,
+		`({maxReceiveSize: -1})`,
+		`({maxSendSize: "1"})`,
+		`({contentSubtype: "unregistered"})`,
+	} {
+		if _, err = parse(js); err == nil {
+			t.Errorf("%s: expected an error", js)
+		}
+	}
+}
+
+func TestParseConnectProxy(t *testing.T) {
+	t.Parallel()
+
+	p, err := parseConnectParams(map[string]interface{}{"proxy": "socks5://proxy.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := p.proxy()("api.example.com:443"); err != nil || u.String() != "socks5://proxy.local:1080" {
+		t.Fatalf("unexpected proxy %v %v", u, err)
+	}
+	if p, err = parseConnectParams(map[string]interface{}{"proxy": false}); err != nil || !p.DisableProxy {
+		t.Fatalf("false should disable the proxy, got %+v %v", p, err)
+	}
+	if u, err := p.proxy()("api.example.com:443"); err != nil || u != nil {
+		t.Fatalf("unexpected proxy %v %v", u, err)
+	}
+	for _, v := range []interface{}{"ftp://proxy.local", int64(1)} {
+		if _, err = parseConnectParams(map[string]interface{}{"proxy": v}); err == nil {
+			t.Errorf("%v: expected an error", v)
+		}
+	}
+}
+
+func TestPooledConnection(t *testing.T) {
+	t.Parallel()
+
+	addr := "pool.test:" + t.Name()
+	connects := 0
+	connect := func() (*xgrpc_conn.Conn, error) {
+		connects++
+		return xgrpc_conn.NewClient("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	tracker := &xgrpc_conn.StateTracker{Target: addr}
+	first, err := pooledConnection(addr, tracker, connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pooledConnection(addr, tracker, connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || connects != 1 {
+		t.Fatalf("the connection should be shared, got %d connections", connects)
+	}
+	if first.ctx.Err() != nil {
+		t.Fatal("the pool entry should be tracked until it is closed")
+	}
+
+	if err = first.close(addr); err != nil {
+		t.Fatal(err)
+	}
+	if first.ctx.Err() == nil {
+		t.Fatal("the tracking should end with the pool entry")
+	}
+	third, err := pooledConnection(addr, tracker, connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = third.close(addr) })
+	if third == first || connects != 2 {
+		t.Fatal("a closed connection should be removed from the pool")
+	}
+}
@@ -0,0 +1,188 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/lib/fsext"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// the google/api files, e.g. the http annotations, can be imported without vendoring them
+	_ "google.golang.org/genproto/googleapis/api/annotations"
+)
+
+// parseProtoFiles compiles the proto files, the names can be directories or patterns
+// matched against the files in the import paths.
+func parseProtoFiles(
+	initEnv *common.InitEnvironment,
+	importPaths, filenames []string,
+) (*descriptorpb.FileDescriptorSet, error) {
+	fs := initEnv.FileSystems["file"]
+	filenames, err := expandProtoFiles(fs, initEnv.GetAbsFilePath, importPaths, filenames)
+	if err != nil {
+		return nil, err
+	}
+
+	return compileProtos(&protocompile.SourceResolver{
+		ImportPaths: importPaths,
+		Accessor: func(filename string) (io.ReadCloser, error) {
+			return fs.Open(initEnv.GetAbsFilePath(filename))
+		},
+	}, filenames...)
+}
+
+// compileProtos compiles the files found by the resolver, the imports it doesn't
+// find are resolved with the files linked into the binary: the well-known types,
+// the google/api and the google/rpc files.
+func compileProtos(sources protocompile.Resolver, filenames ...string) (*descriptorpb.FileDescriptorSet, error) {
+	compiler := protocompile.Compiler{
+		// the comments are kept for describe
+		SourceInfoMode: protocompile.SourceInfoStandard,
+		Resolver: protocompile.WithStandardImports(protocompile.CompositeResolver{
+			sources,
+			protocompile.ResolverFunc(func(filename string) (protocompile.SearchResult, error) {
+				fd, err := protoregistry.GlobalFiles.FindFileByPath(filename)
+				if err != nil {
+					return protocompile.SearchResult{}, err
+				}
+				return protocompile.SearchResult{Desc: fd}, nil
+			}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), filenames...)
+	if err != nil {
+		return nil, err
+	}
+
+	fdset := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]struct{})
+	for _, fd := range files {
+		fdset.File = append(fdset.File, walkFileDescriptors(seen, fd)...)
+	}
+	return fdset, nil
+}
+
+// walkFileDescriptors returns the file and its imports, imports first. The files linked
+// into the binary are left out, they are resolved as is when the set is registered.
+func walkFileDescriptors(seen map[string]struct{}, fd protoreflect.FileDescriptor) []*descriptorpb.FileDescriptorProto {
+	if _, ok := seen[fd.Path()]; ok {
+		return nil
+	}
+	seen[fd.Path()] = struct{}{}
+	if isLinkedFile(fd) {
+		return nil
+	}
+
+	var fds []*descriptorpb.FileDescriptorProto
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		fds = append(fds, walkFileDescriptors(seen, imports.Get(i).FileDescriptor)...)
+	}
+	return append(fds, protodesc.ToFileDescriptorProto(fd))
+}
+
+// wrappedFile is implemented by the files returned by the compiler.
+type wrappedFile interface {
+	Unwrap() protoreflect.FileDescriptor
+}
+
+func isLinkedFile(fd protoreflect.FileDescriptor) bool {
+	if w, ok := fd.(wrappedFile); ok {
+		fd = w.Unwrap()
+	}
+	linked, err := protoregistry.GlobalFiles.FindFileByPath(fd.Path())
+	return err == nil && linked == fd
+}
+
+// expandProtoFiles replaces the directories and the patterns with the proto files they
+// contain, relative to their import path. As with path.Match, a '*' doesn't match a '/'.
+func expandProtoFiles(
+	fs fsext.Fs,
+	absPath func(string) string,
+	importPaths, filenames []string,
+) ([]string, error) {
+	var rtn []string
+	seen := make(map[string]struct{})
+	add := func(name string) {
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			rtn = append(rtn, name)
+		}
+	}
+
+	for _, name := range filenames {
+		pattern := filepath.ToSlash(name)
+		isPattern := strings.ContainsAny(pattern, "*?[")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid proto file pattern %q: %w", name, err)
+		}
+
+		var matches []string
+		for _, importPath := range importPaths {
+			root := pattern
+			if isPattern {
+				root = staticPrefix(pattern)
+			}
+			dir := absPath(filepath.Join(importPath, filepath.FromSlash(root)))
+			if isDir, _ := fsext.IsDir(fs, dir); !isDir {
+				continue
+			}
+			err := fsext.Walk(fs, dir, func(p string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() || filepath.Ext(p) != ".proto" {
+					return err
+				}
+				rel, err := filepath.Rel(dir, p)
+				if err != nil {
+					return err
+				}
+				rel = path.Join(root, filepath.ToSlash(rel))
+				if ok, _ := path.Match(pattern, rel); ok || !isPattern {
+					matches = append(matches, rel)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case len(matches) > 0:
+			sort.Strings(matches)
+			for _, m := range matches {
+				add(m)
+			}
+		case isPattern:
+			return nil, fmt.Errorf("no proto file matches %q", name)
+		default:
+			add(name)
+		}
+	}
+	if len(rtn) == 0 {
+		return nil, errors.New("no proto file to load")
+	}
+	return rtn, nil
+}
+
+// staticPrefix returns the directories of the pattern before the first one with a meta character.
+func staticPrefix(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, p := range parts {
+		if strings.ContainsAny(p, "*?[") {
+			return path.Join(parts[:i]...)
+		}
+	}
+	return pattern
+}
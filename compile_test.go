@@ -0,0 +1,115 @@
+package grpc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"go.k6.io/k6/lib/fsext"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCompileProtos(t *testing.T) {
+	t.Parallel()
+
+	sources := map[string]string{
+		"shared/types.proto": `edition = "2023";
+package compile.test;
+message Item { string id = 1; int32 count = 2 [features.field_presence = IMPLICIT]; }
+`,
+		"service.proto": `syntax = "proto3";
+package compile.test;
+import "google/api/annotations.proto";
+import "google/protobuf/timestamp.proto";
+import "shared/types.proto";
+message GetRequest { string id = 1; google.protobuf.Timestamp at = 2; }
+service Items {
+  rpc Get(GetRequest) returns (compile.test.Item) {
+    option (google.api.http) = { get: "/items/{id}" };
+  }
+}
+`,
+	}
+	fdset, err := compileProtos(&protocompile.SourceResolver{
+		Accessor: protocompile.SourceAccessorFromMap(sources),
+	}, "service.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, fdp := range fdset.GetFile() {
+		names = append(names, fdp.GetName())
+	}
+	if want := []string{"shared/types.proto", "service.proto"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected the files %v, got %v", want, names)
+	}
+
+	parsed := buildDescriptors(fdset)
+	if parsed.files == nil {
+		t.Fatal("the set should build on its own")
+	}
+	var md protoreflect.MethodDescriptor
+	for _, f := range parsed.files {
+		if sd := f.Services().ByName("Items"); sd != nil {
+			md = sd.Methods().ByName("Get")
+		}
+	}
+	if md == nil {
+		t.Fatalf("unexpected methods %v", parsed.methods)
+	}
+	at := md.Input().Fields().ByName("at").Message()
+	if at != (&timestamppb.Timestamp{}).ProtoReflect().Descriptor() {
+		t.Fatal("the well-known types should be the linked ones")
+	}
+
+	if _, err = compileProtos(&protocompile.SourceResolver{
+		Accessor: protocompile.SourceAccessorFromMap(map[string]string{"bad.proto": `syntax = "proto3"; message {`}),
+	}, "bad.proto"); err == nil {
+		t.Fatal("expected a compile error")
+	}
+}
+
+func TestExpandProtoFiles(t *testing.T) {
+	t.Parallel()
+
+	fs := fsext.NewMemMapFs()
+	for _, name := range []string{"/protos/a.proto", "/protos/sub/b.proto", "/protos/sub/c.txt", "/protos/sub/deep/d.proto"} {
+		if err := fsext.WriteFile(fs, name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	abs := func(p string) string { return p }
+
+	tests := []struct {
+		filenames []string
+		want      []string
+		err       bool
+	}{
+		{filenames: []string{"a.proto"}, want: []string{"a.proto"}},
+		{filenames: []string{"missing.proto"}, want: []string{"missing.proto"}},
+		{filenames: []string{"sub"}, want: []string{"sub/b.proto", "sub/deep/d.proto"}},
+		{filenames: []string{"sub/*.proto", "sub/b.proto"}, want: []string{"sub/b.proto"}},
+		{filenames: []string{"*.proto"}, want: []string{"a.proto"}},
+		{filenames: []string{"*/*/*.proto"}, want: []string{"sub/deep/d.proto"}},
+		{filenames: []string{"nothing/*.proto"}, err: true},
+		{filenames: []string{"[.proto"}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := expandProtoFiles(fs, abs, []string{"/protos"}, tt.filenames)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", tt.filenames)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.filenames, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: expected %v, got %v", tt.filenames, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/lib/types"
+	"google.golang.org/grpc/connectivity"
+)
+
+// connectivityStates are the connectivity states by name.
+var connectivityStates = map[string]connectivity.State{ //nolint:gochecknoglobals
+	connectivity.Idle.String():             connectivity.Idle,
+	connectivity.Connecting.String():       connectivity.Connecting,
+	connectivity.Ready.String():            connectivity.Ready,
+	connectivity.TransientFailure.String(): connectivity.TransientFailure,
+	connectivity.Shutdown.String():         connectivity.Shutdown,
+}
+
+// ConnectionState returns the connectivity state of the connection:
+// IDLE, CONNECTING, READY, TRANSIENT_FAILURE or SHUTDOWN.
+func (c *Client) ConnectionState() (string, error) {
+	if c.base().Conn() == nil {
+		return "", errors.New("no gRPC connection, you must call connect first")
+	}
+	return c.base().Conn().State().String(), nil
+}
+
+// WaitForStateChange waits until the connectivity state is not the given one, or the timeout elapses,
+// the default invoke timeout when not set. It returns whether the state changed.
+// An idle connection, as a lazy one, starts connecting.
+func (c *Client) WaitForStateChange(state string, timeout sobek.Value) (bool, error) {
+	if c.base().Conn() == nil {
+		return false, errors.New("no gRPC connection, you must call connect first")
+	}
+	source, ok := connectivityStates[strings.ToUpper(state)]
+	if !ok {
+		return false, fmt.Errorf("invalid connectivity state: %q", state)
+	}
+	var d time.Duration
+	if !isNullish(timeout) {
+		var err error
+		if d, err = types.GetDurationValue(timeout.Export()); err != nil {
+			return false, fmt.Errorf("invalid timeout value: %w", err)
+		}
+	}
+
+	ctx, cancel := c.requestContext(d, nil)
+	defer cancel()
+	if source == connectivity.Idle {
+		c.base().Conn().Connect()
+	}
+	return c.base().Conn().WaitForStateChange(ctx, source), nil
+}
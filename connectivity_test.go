@@ -0,0 +1,34 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/modulestest"
+)
+
+func TestConnectivityParams(t *testing.T) {
+	t.Parallel()
+
+	p, err := parseConnectParams(map[string]interface{}{"lazy": true, "waitForReady": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Lazy || p.Defaults["waitForReady"] != true {
+		t.Fatalf("unexpected params %+v", p)
+	}
+	if _, err = parseConnectParams(map[string]interface{}{"lazy": "yes"}); err == nil {
+		t.Error("expected an error for a string")
+	}
+	if ip := (&invokeParams{WaitForReady: true}); len(ip.callOptions()) != 1 {
+		t.Error("waitForReady should set a call option")
+	}
+
+	c := &Client{vu: &modulestest.VU{RuntimeField: sobek.New()}}
+	if _, err = c.ConnectionState(); err == nil {
+		t.Error("expected an error without a connection")
+	}
+	if _, err = c.WaitForStateChange("IDLE", nil); err == nil {
+		t.Error("expected an error without a connection")
+	}
+}
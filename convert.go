@@ -0,0 +1,616 @@
+package grpc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/sobek"
+	"github.com/shlsky/xk6-grpc/xgrpc_conn"
+	"go.k6.io/k6/lib/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// int64Mapping is the JS type of the 64-bit integers of a response.
+type int64Mapping int
+
+const (
+	int64AsBigInt int64Mapping = iota
+	int64AsNumber
+	int64AsString
+)
+
+// typeMapping configures the direct conversion between JS values and protobuf messages,
+// which replaces the JSON round trip of the requests and the responses.
+//
+// The responses map the 64-bit integers according to Int64, the bytes to ArrayBuffers,
+// google.protobuf.Timestamp to Date, google.protobuf.Duration to milliseconds and
+// the enums to their name or, with EnumNumbers, their number.
+type typeMapping struct {
+	Int64       int64Mapping
+	EnumNumbers bool
+}
+
+func parseTypeMapping(v interface{}) (*typeMapping, error) {
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("typeMapping must be an object")
+	}
+	mapping := &typeMapping{}
+	for k, rv := range raw {
+		switch k {
+		case "int64":
+			switch rv {
+			case "bigint":
+				mapping.Int64 = int64AsBigInt
+			case "number":
+				mapping.Int64 = int64AsNumber
+			case "string":
+				mapping.Int64 = int64AsString
+			default:
+				return nil, fmt.Errorf("invalid typeMapping.int64 value: '%#v', it needs to be bigint, number or string", rv)
+			}
+		case "enums":
+			switch rv {
+			case "name":
+				mapping.EnumNumbers = false
+			case "number":
+				mapping.EnumNumbers = true
+			default:
+				return nil, fmt.Errorf("invalid typeMapping.enums value: '%#v', it needs to be name or number", rv)
+			}
+		default:
+			return nil, fmt.Errorf("unknown typeMapping param: %q", k)
+		}
+	}
+	return mapping, nil
+}
+
+// jsConverter converts protobuf messages from and to JS values of its runtime,
+// it also follows the JSON options: the field names, the enum numbers,
+// the unpopulated fields and the unknown fields are handled as protojson does.
+type jsConverter struct {
+	rt      *sobek.Runtime
+	mapping typeMapping
+	json    xgrpc_conn.JSONOptions
+	types   xgrpc_conn.TypeResolver
+	// violations collects the errors of the fields, instead of failing on the first one.
+	violations *[]violation
+}
+
+// fieldError is a conversion error of the field at path.
+type fieldError struct {
+	path string
+	err  error
+}
+
+func fieldErrorf(path, format string, args ...interface{}) error {
+	return &fieldError{path: path, err: fmt.Errorf(format, args...)}
+}
+
+func (e *fieldError) Error() string {
+	return e.path + ": " + e.err.Error()
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.err
+}
+
+// fail returns err, or records it and returns nil when the converter collects the violations.
+func (c jsConverter) fail(err error) error {
+	var fe *fieldError
+	if c.violations == nil || !errors.As(err, &fe) {
+		return err
+	}
+	*c.violations = append(*c.violations, newViolation(fe.path, ruleType, fe.err.Error()))
+	return nil
+}
+
+// messageToJS builds the JS object of a message, like protojson with EmitUnpopulated
+// every field is set but the unpopulated oneof ones.
+func (c jsConverter) messageToJS(m protoreflect.Message) sobek.Value {
+	if v, ok := c.wellKnownToJS(m); ok {
+		return v
+	}
+	obj := c.rt.NewObject()
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !m.Has(fd) && (!c.json.EmitUnpopulated || fd.ContainingOneof() != nil) {
+			continue
+		}
+		_ = obj.Set(c.fieldName(fd), c.fieldToJS(fd, m, m.Get(fd)))
+	}
+	return obj
+}
+
+func (c jsConverter) fieldName(fd protoreflect.FieldDescriptor) string {
+	if c.json.UseProtoNames {
+		return string(fd.Name())
+	}
+	return fd.JSONName()
+}
+
+func (c jsConverter) fieldToJS(fd protoreflect.FieldDescriptor, parent protoreflect.Message, v protoreflect.Value) sobek.Value {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		items := make([]interface{}, 0, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			items = append(items, c.singularToJS(fd, list.Get(i)))
+		}
+		return c.rt.NewArray(items...)
+	case fd.IsMap():
+		obj := c.rt.NewObject()
+		v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			_ = obj.Set(k.String(), c.singularToJS(fd.MapValue(), mv))
+			return true
+		})
+		return obj
+	case fd.Message() != nil && !parent.Has(fd):
+		return sobek.Null()
+	}
+	return c.singularToJS(fd, v)
+}
+
+func (c jsConverter) singularToJS(fd protoreflect.FieldDescriptor, v protoreflect.Value) sobek.Value {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return c.messageToJS(v.Message())
+	case protoreflect.EnumKind:
+		if fd.Enum().FullName() == "google.protobuf.NullValue" {
+			return sobek.Null()
+		}
+		if !c.mapping.EnumNumbers && !c.json.UseEnumNumbers {
+			if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+				return c.rt.ToValue(string(ev.Name()))
+			}
+		}
+		return c.rt.ToValue(int64(v.Enum()))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return c.int64ToJS(big.NewInt(v.Int()), float64(v.Int()))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return c.int64ToJS(new(big.Int).SetUint64(v.Uint()), float64(v.Uint()))
+	case protoreflect.BytesKind:
+		b := make([]byte, len(v.Bytes()))
+		copy(b, v.Bytes())
+		return c.rt.ToValue(c.rt.NewArrayBuffer(b))
+	}
+	return c.rt.ToValue(v.Interface())
+}
+
+func (c jsConverter) int64ToJS(n *big.Int, f float64) sobek.Value {
+	switch c.mapping.Int64 {
+	case int64AsNumber:
+		return c.rt.ToValue(f)
+	case int64AsString:
+		return c.rt.ToValue(n.String())
+	default:
+		return c.rt.ToValue(n)
+	}
+}
+
+// wellKnownToJS converts the well-known types having a natural JS representation,
+// the other ones keep their protojson representation.
+func (c jsConverter) wellKnownToJS(m protoreflect.Message) (sobek.Value, bool) {
+	md := m.Descriptor()
+	if !isWellKnownType(md) {
+		return nil, false
+	}
+	fields := md.Fields()
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		t := time.Unix(m.Get(fields.ByName("seconds")).Int(), m.Get(fields.ByName("nanos")).Int())
+		date, err := c.rt.New(c.rt.Get("Date"), c.rt.ToValue(t.UnixMilli()))
+		if err != nil {
+			return nil, false
+		}
+		return date, true
+	case "google.protobuf.Duration":
+		d := time.Duration(m.Get(fields.ByName("seconds")).Int())*time.Second +
+			time.Duration(m.Get(fields.ByName("nanos")).Int())
+		return c.rt.ToValue(float64(d) / float64(time.Millisecond)), true
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value",
+		"google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		fd := fields.ByName("value")
+		return c.singularToJS(fd, m.Get(fd)), true
+	}
+
+	b, err := protojson.MarshalOptions{
+		Resolver:        c.types,
+		UseProtoNames:   c.json.UseProtoNames,
+		UseEnumNumbers:  c.json.UseEnumNumbers,
+		EmitUnpopulated: c.json.EmitUnpopulated,
+	}.Marshal(m.Interface())
+	if err != nil {
+		return nil, false
+	}
+	var v interface{}
+	if err = json.Unmarshal(b, &v); err != nil {
+		return nil, false
+	}
+	return c.rt.ToValue(v), true
+}
+
+// messageFromJS builds a message of the given type from a JS object, it accepts
+// the values produced by messageToJS as well as the protojson representation.
+func (c jsConverter) messageFromJS(md protoreflect.MessageDescriptor, v sobek.Value) (*dynamicpb.Message, error) {
+	m := dynamicpb.NewMessage(md)
+	if err := c.setMessage(m, v, string(md.Name())); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c jsConverter) setMessage(m protoreflect.Message, v sobek.Value, path string) error {
+	if ok, err := c.setWellKnown(m, v, path); ok {
+		return err
+	}
+	obj, ok := v.(*sobek.Object)
+	if !ok {
+		return fieldErrorf(path, "expected an object, got %s", v.String())
+	}
+	fields := m.Descriptor().Fields()
+	for _, k := range obj.Keys() {
+		fd := fields.ByJSONName(k)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(k))
+		}
+		if fd == nil {
+			if c.json.DiscardUnknown {
+				continue
+			}
+			if c.violations != nil {
+				*c.violations = append(*c.violations, newViolation(path+"."+k, ruleUnknownField, "unknown field"))
+				continue
+			}
+			return fieldErrorf(path, "unknown field %q", k)
+		}
+		fv := obj.Get(k)
+		// like protojson a null is an unset field, but for google.protobuf.Value where it is a NullValue
+		if isNullish(fv) && (fd.Message() == nil || fd.Message().FullName() != "google.protobuf.Value") {
+			continue
+		}
+		if err := c.setField(m, fd, fv, path+"."+k); err != nil {
+			if err = c.fail(err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c jsConverter) setField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v sobek.Value, path string) error {
+	switch {
+	case fd.IsList():
+		obj, ok := v.(*sobek.Object)
+		if !ok || obj.ClassName() != classArray {
+			return fieldErrorf(path, "expected an array, got %s", v.String())
+		}
+		list := m.Mutable(fd).List()
+		length := int(obj.Get("length").ToInteger())
+		for i := 0; i < length; i++ {
+			// the holes of a sparse array are undefined
+			elem := obj.Get(strconv.Itoa(i))
+			if elem == nil {
+				elem = sobek.Undefined()
+			}
+			ev, err := c.singularFromJS(fd, list.NewElement, elem, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				if err = c.fail(err); err != nil {
+					return err
+				}
+				continue
+			}
+			list.Append(ev)
+		}
+	case fd.IsMap():
+		obj, ok := v.(*sobek.Object)
+		if !ok || obj.ClassName() == classArray {
+			return fieldErrorf(path, "expected an object, got %s", v.String())
+		}
+		entries := m.Mutable(fd).Map()
+		for _, k := range obj.Keys() {
+			key, err := parseMapKey(fd.MapKey(), k)
+			if err != nil {
+				if err = c.fail(fieldErrorf(path, "%w", err)); err != nil {
+					return err
+				}
+				continue
+			}
+			ev, err := c.singularFromJS(fd.MapValue(), entries.NewValue, obj.Get(k), fmt.Sprintf("%s[%q]", path, k))
+			if err != nil {
+				if err = c.fail(err); err != nil {
+					return err
+				}
+				continue
+			}
+			entries.Set(key, ev)
+		}
+	default:
+		fv, err := c.singularFromJS(fd, func() protoreflect.Value { return m.NewField(fd) }, v, path)
+		if err != nil {
+			return err
+		}
+		m.Set(fd, fv)
+	}
+	return nil
+}
+
+func (c jsConverter) singularFromJS(
+	fd protoreflect.FieldDescriptor,
+	newValue func() protoreflect.Value,
+	v sobek.Value,
+	path string,
+) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		mv := newValue()
+		if err := c.setMessage(mv.Message(), v, path); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return mv, nil
+	case protoreflect.BytesKind:
+		if s, ok := v.Export().(string); ok {
+			b, err := decodeBase64(s)
+			if err != nil {
+				return protoreflect.Value{}, fieldErrorf(path, "%w", err)
+			}
+			return protoreflect.ValueOfBytes(b), nil
+		}
+		var b []byte
+		if err := c.rt.ExportTo(v, &b); err != nil {
+			return protoreflect.Value{}, fieldErrorf(path, "expected an ArrayBuffer, a typed array or a base64 string")
+		}
+		return protoreflect.ValueOfBytes(append([]byte(nil), b...)), nil
+	}
+	pv, err := toProtoScalar(fd, v.Export())
+	if err != nil {
+		return protoreflect.Value{}, fieldErrorf(path, "%w", err)
+	}
+	return pv, nil
+}
+
+// setWellKnown sets the well-known types from their natural JS representation,
+// the other ones are expected in their protojson representation.
+func (c jsConverter) setWellKnown(m protoreflect.Message, v sobek.Value, path string) (bool, error) {
+	md := m.Descriptor()
+	if !isWellKnownType(md) {
+		return false, nil
+	}
+	fields := md.Fields()
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		var t time.Time
+		switch val := v.Export().(type) {
+		case time.Time:
+			t = val
+		case string:
+			var err error
+			if t, err = time.Parse(time.RFC3339Nano, val); err != nil {
+				return true, fieldErrorf(path, "%w", err)
+			}
+		case int64, float64:
+			ms, _ := toFloat64(val)
+			t = time.UnixMilli(0).Add(time.Duration(ms * float64(time.Millisecond)))
+		default:
+			return true, fieldErrorf(path, "expected a Date, a RFC 3339 string or milliseconds")
+		}
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+		return true, nil
+	case "google.protobuf.Duration":
+		// milliseconds or a Go duration string, which includes the protojson format e.g. "1.5s"
+		d, err := types.GetDurationValue(v.Export())
+		if err != nil {
+			return true, fieldErrorf(path, "%w", err)
+		}
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(int64(d/time.Second)))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(d%time.Second)))
+		return true, nil
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value",
+		"google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		fd := fields.ByName("value")
+		fv, err := c.singularFromJS(fd, nil, v, path)
+		if err != nil {
+			return true, err
+		}
+		m.Set(fd, fv)
+		return true, nil
+	}
+
+	b, err := json.Marshal(v.Export())
+	if err != nil {
+		return true, fieldErrorf(path, "%w", err)
+	}
+	opts := protojson.UnmarshalOptions{Resolver: c.types, DiscardUnknown: c.json.DiscardUnknown}
+	if err = opts.Unmarshal(b, m.Interface()); err != nil {
+		return true, fieldErrorf(path, "%w", err)
+	}
+	return true, nil
+}
+
+func isWellKnownType(md protoreflect.MessageDescriptor) bool {
+	return md.ParentFile() != nil && md.ParentFile().Package() == "google.protobuf"
+}
+
+// classArray is the class name of the JS arrays.
+const classArray = "Array"
+
+func isNullish(v sobek.Value) bool {
+	return v == nil || sobek.IsUndefined(v) || sobek.IsNull(v)
+}
+
+// decodeBase64 accepts the standard and the URL encodings, padded or not, as protojson does.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func parseMapKey(fd protoreflect.FieldDescriptor, s string) (protoreflect.MapKey, error) {
+	v, err := toProtoScalar(fd, s)
+	if err != nil {
+		return protoreflect.MapKey{}, fmt.Errorf("invalid map key %q: %w", s, err)
+	}
+	return v.MapKey(), nil
+}
+
+// toProtoScalar converts an exported JS value into a value of the field's scalar kind,
+// strings are parsed for the numeric and boolean kinds and base64-decoded for bytes,
+// like protojson only a string is accepted for the string kind.
+func toProtoScalar(fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		if s, ok := v.(string); ok {
+			return protoreflect.ValueOfString(s), nil
+		}
+	case protoreflect.BytesKind:
+		switch b := v.(type) {
+		case string:
+			decoded, err := decodeBase64(b)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfBytes(decoded), nil
+		case []byte:
+			return protoreflect.ValueOfBytes(b), nil
+		case sobek.ArrayBuffer:
+			return protoreflect.ValueOfBytes(b.Bytes()), nil
+		}
+	case protoreflect.BoolKind:
+		switch b := v.(type) {
+		case bool:
+			return protoreflect.ValueOfBool(b), nil
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfBool(parsed), nil
+		}
+	case protoreflect.EnumKind:
+		if s, ok := v.(string); ok {
+			if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+				return protoreflect.ValueOfEnum(ev.Number()), nil
+			}
+			if _, err := strconv.ParseInt(s, 10, 32); err != nil {
+				return protoreflect.Value{}, fmt.Errorf("unknown value %q of enum %s", s, fd.Enum().FullName())
+			}
+		}
+		n, err := toInt64(v, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := toInt64(v, math.MinInt32, math.MaxInt32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := toInt64(v, math.MinInt64, math.MaxInt64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := toUint64(v, math.MaxUint32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := toUint64(v, math.MaxUint64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := toFloat64(v)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := toFloat64(v)
+		return protoreflect.ValueOfFloat64(f), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("can't convert %T to %s", v, fd.Kind())
+}
+
+func toInt64(v interface{}, lo, hi int64) (int64, error) {
+	var n int64
+	switch val := v.(type) {
+	case int64:
+		n = val
+	case *big.Int:
+		if !val.IsInt64() {
+			return 0, fmt.Errorf("%s is out of range", val)
+		}
+		n = val.Int64()
+	case float64:
+		if val != math.Trunc(val) || val < math.MinInt64 || val >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v is not an integer", val)
+		}
+		n = int64(val)
+	case string:
+		var err error
+		if n, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("can't convert %T to an integer", v)
+	}
+	if n < lo || n > hi {
+		return 0, fmt.Errorf("%d is out of range", n)
+	}
+	return n, nil
+}
+
+func toUint64(v interface{}, hi uint64) (uint64, error) {
+	var n uint64
+	switch val := v.(type) {
+	case int64:
+		if val < 0 {
+			return 0, fmt.Errorf("%d is out of range", val)
+		}
+		n = uint64(val)
+	case *big.Int:
+		if !val.IsUint64() {
+			return 0, fmt.Errorf("%s is out of range", val)
+		}
+		n = val.Uint64()
+	case float64:
+		if val != math.Trunc(val) || val < 0 || val >= math.MaxUint64 {
+			return 0, fmt.Errorf("%v is not an unsigned integer", val)
+		}
+		n = uint64(val)
+	case string:
+		var err error
+		if n, err = strconv.ParseUint(val, 10, 64); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("can't convert %T to an unsigned integer", v)
+	}
+	if n > hi {
+		return 0, fmt.Errorf("%d is out of range", n)
+	}
+	return n, nil
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case int64:
+		return float64(val), nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(val).Float64()
+		return f, nil
+	case string:
+		return strconv.ParseFloat(val, 64)
+	}
+	return 0, fmt.Errorf("can't convert %T to a number", v)
+}
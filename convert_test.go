@@ -0,0 +1,163 @@
+package grpc
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/grafana/sobek"
+	"github.com/shlsky/xk6-grpc/xgrpc_conn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const convertTestProto = `syntax = "proto3";
+package conv;
+
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/wrappers.proto";
+import "google/protobuf/struct.proto";
+
+enum Level {
+  LOW = 0;
+  HIGH = 1;
+}
+
+message Child {
+  string name = 1;
+}
+
+message Sample {
+  int64 big = 1;
+  uint64 ubig = 2;
+  bytes blob = 3;
+  Level level = 4;
+  google.protobuf.Timestamp at = 5;
+  google.protobuf.Duration took = 6;
+  google.protobuf.Int64Value wrapped = 7;
+  repeated Child children = 8;
+  map<string, int32> counts = 9;
+  Child missing = 10;
+  google.protobuf.Struct extra = 11;
+  string display_name = 14;
+  oneof choice {
+    string text = 12;
+    int32 number = 13;
+  }
+}
+`
+
+func TestConverterRoundTrip(t *testing.T) {
+	fd := parseTestProto(t, convertTestProto)
+	md := fd.Messages().ByName("Sample")
+	rt := sobek.New()
+
+	in, err := rt.RunString(`({
+		big: 9007199254740993n,
+		ubig: "18446744073709551615",
+		blob: new Uint8Array([1, 2, 3]),
+		level: "HIGH",
+		at: new Date(1700000000123),
+		took: "1.5s",
+		wrapped: 7,
+		children: [{name: "a"}, {name: "b"}],
+		counts: {x: 1},
+		extra: {k: [1, "v"]},
+		number: 3,
+	})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conv := jsConverter{rt: rt, json: xgrpc_conn.DefaultJSONOptions()}
+	msg, err := conv.messageFromJS(md, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := md.Fields()
+	get := func(name string) protoreflect.Value { return msg.Get(fields.ByName(protoreflect.Name(name))) }
+	if get("big").Int() != 9007199254740993 || get("ubig").Uint() != 18446744073709551615 {
+		t.Errorf("unexpected 64-bit integers %v %v", get("big"), get("ubig"))
+	}
+	if get("level").Enum() != 1 || get("number").Int() != 3 || len(get("blob").Bytes()) != 3 {
+		t.Errorf("unexpected level/number/blob %v %v %v", get("level"), get("number"), get("blob"))
+	}
+
+	out := conv.messageToJS(msg).ToObject(rt)
+	if big, ok := out.Get("big").Export().(*big.Int); !ok || big.String() != "9007199254740993" {
+		t.Errorf("expected a BigInt, got %v", out.Get("big"))
+	}
+	if at, ok := out.Get("at").Export().(time.Time); !ok || at.UnixMilli() != 1700000000123 {
+		t.Errorf("expected a Date, got %v", out.Get("at"))
+	}
+	if took := out.Get("took").ToFloat(); took != 1500 {
+		t.Errorf("expected 1500ms, got %v", took)
+	}
+	if out.Get("level").String() != "HIGH" || out.Get("wrapped").String() != "7" {
+		t.Errorf("unexpected level/wrapped %v %v", out.Get("level"), out.Get("wrapped"))
+	}
+	if _, ok := out.Get("blob").Export().(sobek.ArrayBuffer); !ok {
+		t.Errorf("expected an ArrayBuffer, got %v", out.Get("blob"))
+	}
+	if !sobek.IsNull(out.Get("missing")) || out.Get("text") != nil {
+		t.Errorf("expected a null message and no unset oneof field, got %v %v", out.Get("missing"), out.Get("text"))
+	}
+	if out.Get("children").ToObject(rt).Get("1").ToObject(rt).Get("name").String() != "b" {
+		t.Errorf("unexpected children %v", out.Get("children"))
+	}
+
+	conv.mapping = typeMapping{Int64: int64AsString, EnumNumbers: true}
+	out = conv.messageToJS(msg).ToObject(rt)
+	if out.Get("ubig").Export() != "18446744073709551615" || out.Get("level").Export() != int64(1) {
+		t.Errorf("unexpected mapped values %v %v", out.Get("ubig"), out.Get("level"))
+	}
+
+	bad, _ := rt.RunString(`({nope: 1})`)
+	if _, err = conv.messageFromJS(md, bad); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+
+	conv.json = xgrpc_conn.JSONOptions{UseProtoNames: true, DiscardUnknown: true}
+	if _, err = conv.messageFromJS(md, bad); err != nil {
+		t.Errorf("unknown fields must be discarded, got %v", err)
+	}
+	out = conv.messageToJS(msg).ToObject(rt)
+	if out.Get("display_name") != nil || out.Get("missing") != nil || out.Get("big") == nil {
+		t.Errorf("only the populated fields must be set, got %v", out.Keys())
+	}
+	named, _ := rt.RunString(`({display_name: "x"})`)
+	if msg, err = conv.messageFromJS(md, named); err != nil {
+		t.Fatal(err)
+	}
+	if out = conv.messageToJS(msg).ToObject(rt); out.Get("display_name").String() != "x" {
+		t.Errorf("expected the proto field name, got %v", out.Keys())
+	}
+}
+
+func TestConverterWrongTypes(t *testing.T) {
+	fd := parseTestProto(t, convertTestProto)
+	md := fd.Messages().ByName("Sample")
+	rt := sobek.New()
+	conv := jsConverter{rt: rt, json: xgrpc_conn.DefaultJSONOptions()}
+
+	for js, path := range map[string]string{
+		`({children: {a: 1}})`:         "Sample.children",
+		`({children: {length: 2}})`:    "Sample.children",
+		`({children: [, {name: 1}]})`:  "Sample.children[0]",
+		`({counts: [1, 2]})`:           "Sample.counts",
+		`({display_name: {a: 1}})`:     "Sample.display_name",
+		`({text: 1})`:                  "Sample.text",
+		`({children: [{name: true}]})`: "Sample.children[0].name",
+	} {
+		v, err := rt.RunString(js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conv.messageFromJS(md, v)
+		var fe *fieldError
+		if !errors.As(err, &fe) || fe.path != path {
+			t.Errorf("expected an error of %s for %s, got %v", path, js, err)
+		}
+	}
+}
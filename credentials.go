@@ -0,0 +1,256 @@
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shlsky/xk6-grpc/xgrpc_conn"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/netext"
+	"go.k6.io/k6/lib/types"
+)
+
+// tokenSources caches the token sources by their credentials params,
+// so the tokens are shared by the VUs and survive reconnections.
+var tokenSources sync.Map
+
+// credentialsParams are the params of the per-RPC credentials, the type is bearer, oauth2 or jwt.
+type credentialsParams struct {
+	Type   string
+	Token  string
+	OAuth2 xgrpc_conn.ClientCredentials
+	JWT    xgrpc_conn.JWT
+	// key identifies the params in tokenSources.
+	key string
+}
+
+func parseCredentialsParams(v interface{}) (*credentialsParams, error) {
+	if token, ok := v.(string); ok {
+		v = map[string]interface{}{"type": "bearer", "token": token}
+	}
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid credentials value: '%#v', it needs to be a token or an object", v)
+	}
+	key, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials value: %w", err)
+	}
+	params := &credentialsParams{key: string(key)}
+	params.Type, _ = raw["type"].(string)
+
+	switch params.Type {
+	case "bearer":
+		err = params.parseBearer(raw)
+	case "oauth2":
+		err = params.parseOAuth2(raw)
+	case "jwt":
+		err = params.parseJWT(raw)
+	default:
+		return nil, fmt.Errorf("invalid credentials type: '%#v', it needs to be bearer, oauth2 or jwt", raw["type"])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s credentials: %w", params.Type, err)
+	}
+	return params, nil
+}
+
+func (p *credentialsParams) parseBearer(raw map[string]interface{}) error {
+	for k, v := range raw {
+		switch k {
+		case "type":
+		case "token":
+			var ok bool
+			if p.Token, ok = v.(string); !ok || p.Token == "" {
+				return fmt.Errorf("invalid token value: '%#v', it needs to be a non-empty string", v)
+			}
+		default:
+			return fmt.Errorf("unknown param: %q", k)
+		}
+	}
+	if p.Token == "" {
+		return errors.New("the token is required")
+	}
+	return nil
+}
+
+func (p *credentialsParams) parseOAuth2(raw map[string]interface{}) error {
+	cc := &p.OAuth2
+	for k, v := range raw {
+		var err error
+		switch k {
+		case "type":
+		case "tokenUrl":
+			cc.TokenURL, err = stringParam(k, v)
+		case "clientId":
+			cc.ClientID, err = stringParam(k, v)
+		case "clientSecret":
+			cc.ClientSecret, err = stringParam(k, v)
+		case "scopes":
+			cc.Scopes, err = stringsParam(k, v)
+		case "audience":
+			var audience string
+			if audience, err = stringParam(k, v); err == nil {
+				setParam(&cc.Params, "audience", audience)
+			}
+		case "params":
+			params, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid params value: '%#v', it needs to be an object", v)
+			}
+			for name, value := range params {
+				s, ok := value.(string)
+				if !ok {
+					return fmt.Errorf("invalid %q param value: '%#v', it needs to be a string", name, value)
+				}
+				setParam(&cc.Params, name, s)
+			}
+		case "secretInBody":
+			var ok bool
+			if cc.SecretInBody, ok = v.(bool); !ok {
+				return fmt.Errorf("invalid secretInBody value: '%#v', it needs to be boolean", v)
+			}
+		default:
+			return fmt.Errorf("unknown param: %q", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if cc.TokenURL == "" || cc.ClientID == "" {
+		return errors.New("the tokenUrl and the clientId are required")
+	}
+	return nil
+}
+
+func (p *credentialsParams) parseJWT(raw map[string]interface{}) error {
+	j := &p.JWT
+	for k, v := range raw {
+		var err error
+		switch k {
+		case "type":
+		case "algorithm":
+			j.Algorithm, err = stringParam(k, v)
+		case "key":
+			var key string
+			key, err = stringParam(k, v)
+			j.Key = []byte(key)
+		case "keyFile":
+			// the VUs can't read the files, they are read in the init context by open
+			return errors.New("keyFile isn't supported, set the key to the content read by open(path) in the init context")
+		case "keyId":
+			j.KeyID, err = stringParam(k, v)
+		case "issuer":
+			j.Issuer, err = stringParam(k, v)
+		case "subject":
+			j.Subject, err = stringParam(k, v)
+		case "audience":
+			j.Audience, err = stringsParam(k, v)
+		case "claims":
+			var ok bool
+			if j.Claims, ok = v.(map[string]interface{}); !ok {
+				return fmt.Errorf("invalid claims value: '%#v', it needs to be an object", v)
+			}
+		case "lifetime":
+			if j.Lifetime, err = types.GetDurationValue(v); err != nil {
+				err = fmt.Errorf("invalid lifetime value: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown param: %q", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if j.Algorithm == "" {
+		j.Algorithm = "HS256"
+	}
+	if len(j.Key) == 0 {
+		return errors.New("the key is required")
+	}
+	return nil
+}
+
+// tokenSource returns the cached token source of the params. It is shared by the VUs, so the
+// OAuth2 tokens are requested with a dialer of the blockHostnames, blacklistIPs and hosts
+// options of the VU's dialer whose data isn't counted for the VU, and with the TLS config of
+// the options, the same for every VU.
+func (p *credentialsParams) tokenSource(state *lib.State) (xgrpc_conn.TokenSource, error) {
+	if p.Type == "bearer" {
+		return xgrpc_conn.StaticToken(p.Token), nil
+	}
+	if source, ok := tokenSources.Load(p.key); ok {
+		return source.(xgrpc_conn.TokenSource), nil
+	}
+
+	var source xgrpc_conn.TokenSource
+	switch p.Type {
+	case "oauth2":
+		cc := p.OAuth2
+		cc.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				DialContext:         sharedDialer(state).DialContext,
+				TLSClientConfig:     state.TLSConfig,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		}
+		source = cc
+	case "jwt":
+		var err error
+		if source, err = p.JWT.TokenSource(); err != nil {
+			return nil, err
+		}
+	}
+	cached, _ := tokenSources.LoadOrStore(p.key, xgrpc_conn.CachedTokens(source))
+	return cached.(xgrpc_conn.TokenSource), nil
+}
+
+// sharedDialer returns a dialer not bound to the VU, with the options of its dialer.
+func sharedDialer(state *lib.State) lib.DialContexter {
+	d, ok := state.Dialer.(*netext.Dialer)
+	if !ok {
+		return state.Dialer
+	}
+	shared := netext.NewDialer(d.Dialer, d.Resolver)
+	shared.Blacklist, shared.BlockedHostnames, shared.Hosts = d.Blacklist, d.BlockedHostnames, d.Hosts
+	return shared
+}
+
+func stringParam(name string, v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid %s value: '%#v', it needs to be a string", name, v)
+	}
+	return s, nil
+}
+
+// stringsParam accepts a string or an array of strings.
+func stringsParam(name string, v interface{}) ([]string, error) {
+	switch val := v.(type) {
+	case string:
+		return []string{val}, nil
+	case []interface{}:
+		list := make([]string, 0, len(val))
+		for _, item := range val {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s value: '%#v', it needs to be an array of strings", name, v)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("invalid %s value: '%#v', it needs to be a string or an array of strings", name, v)
+}
+
+func setParam(params *map[string]string, name, value string) {
+	if *params == nil {
+		*params = make(map[string]string)
+	}
+	(*params)[name] = value
+}
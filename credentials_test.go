@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCredentialsParams(t *testing.T) {
+	t.Parallel()
+
+	p, err := parseCredentialsParams("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != "bearer" || p.Token != "abc" {
+		t.Fatalf("a string should be a bearer token, got %+v", p)
+	}
+
+	p, err = parseCredentialsParams(map[string]interface{}{
+		"type":         "oauth2",
+		"tokenUrl":     "https://auth.example.com/token",
+		"clientId":     "client",
+		"clientSecret": "secret",
+		"scopes":       []interface{}{"read", "write"},
+		"audience":     "api",
+		"params":       map[string]interface{}{"resource": "orders"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.OAuth2.Scopes) != 2 || p.OAuth2.Params["audience"] != "api" || p.OAuth2.Params["resource"] != "orders" {
+		t.Fatalf("unexpected oauth2 params %+v", p.OAuth2)
+	}
+
+	p, err = parseCredentialsParams(map[string]interface{}{
+		"type":     "jwt",
+		"key":      "secret",
+		"audience": "api",
+		"lifetime": "5m",
+		"claims":   map[string]interface{}{"scope": "read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.JWT.Algorithm != "HS256" || p.JWT.Lifetime != 5*time.Minute || p.JWT.Audience[0] != "api" {
+		t.Fatalf("unexpected jwt params %+v", p.JWT)
+	}
+	source, err := p.tokenSource(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := p.tokenSource(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != again {
+		t.Fatal("the token source should be shared by the same params")
+	}
+
+	for _, raw := range []interface{}{
+		true,
+		map[string]interface{}{"type": "basic"},
+		map[string]interface{}{"type": "bearer"},
+		map[string]interface{}{"type": "bearer", "token": "abc", "unknown": 1},
+		map[string]interface{}{"type": "oauth2", "clientId": "client"},
+		map[string]interface{}{"type": "oauth2", "tokenUrl": "u", "clientId": "c", "scopes": int64(1)},
+		map[string]interface{}{"type": "jwt", "algorithm": "HS256"},
+		map[string]interface{}{"type": "jwt", "key": "k", "lifetime": "x"},
+		map[string]interface{}{"type": "jwt", "keyFile": "key.pem"},
+	} {
+		if _, err = parseCredentialsParams(raw); err == nil {
+			t.Errorf("%v: expected an error", raw)
+		}
+	}
+}
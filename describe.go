@@ -0,0 +1,318 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Describe returns the schema of a loaded method: its input and output messages with the
+// messages and enums they reference, keyed by full name, and their JSON Schemas.
+// The comments are set when the descriptors hold the source info, e.g. loaded from proto files.
+// The JSON Schemas accept the JSON and the proto names of the fields, as protojson does.
+func (c *Client) Describe(method string) (map[string]interface{}, error) {
+	md, err := c.methodDescriptor(method)
+	if err != nil {
+		return nil, err
+	}
+
+	d := describer{
+		useProtoNames: c.defaults.JSONOptions.UseProtoNames,
+		messages:      make(map[string]interface{}),
+		enums:         make(map[string]interface{}),
+	}
+	return map[string]interface{}{
+		"fullMethod":      fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name()),
+		"service":         string(md.Parent().FullName()),
+		"name":            string(md.Name()),
+		"clientStreaming": md.IsStreamingClient(),
+		"serverStreaming": md.IsStreamingServer(),
+		"comment":         comment(md),
+		"input":           d.message(md.Input()),
+		"output":          d.message(md.Output()),
+		"messages":        d.messages,
+		"enums":           d.enums,
+		"jsonSchema": map[string]interface{}{
+			"input":  d.jsonSchema(md.Input()),
+			"output": d.jsonSchema(md.Output()),
+		},
+	}, nil
+}
+
+// methodDescriptor returns the descriptor of a loaded method, the leading slash is optional.
+func (c *Client) methodDescriptor(method string) (protoreflect.MethodDescriptor, error) {
+	if method == "" {
+		return nil, errors.New("method cannot be empty")
+	}
+	return c.base().Method(method)
+}
+
+// describer builds the schemas of the messages, the referenced messages and enums are
+// described once, so the recursive messages are supported.
+type describer struct {
+	useProtoNames bool
+	messages      map[string]interface{}
+	enums         map[string]interface{}
+}
+
+func (d describer) message(md protoreflect.MessageDescriptor) map[string]interface{} {
+	name := string(md.FullName())
+	if s, ok := d.messages[name].(map[string]interface{}); ok {
+		return s
+	}
+	schema := map[string]interface{}{
+		"name":    name,
+		"comment": comment(md),
+	}
+	d.messages[name] = schema
+
+	fields := md.Fields()
+	list := make([]interface{}, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		list = append(list, d.field(fields.Get(i)))
+	}
+	schema["fields"] = list
+
+	oneofs := md.Oneofs()
+	oneofList := make([]interface{}, 0, oneofs.Len())
+	for i := 0; i < oneofs.Len(); i++ {
+		od := oneofs.Get(i)
+		if od.IsSynthetic() {
+			continue
+		}
+		names := make([]interface{}, 0, od.Fields().Len())
+		for j := 0; j < od.Fields().Len(); j++ {
+			names = append(names, string(od.Fields().Get(j).Name()))
+		}
+		oneofList = append(oneofList, map[string]interface{}{
+			"name":    string(od.Name()),
+			"fields":  names,
+			"comment": comment(od),
+		})
+	}
+	schema["oneofs"] = oneofList
+	return schema
+}
+
+func (d describer) field(fd protoreflect.FieldDescriptor) map[string]interface{} {
+	field := map[string]interface{}{
+		"name":     string(fd.Name()),
+		"jsonName": fd.JSONName(),
+		"number":   int64(fd.Number()),
+		"repeated": fd.IsList(),
+		"map":      fd.IsMap(),
+		"optional": false,
+		"comment":  comment(fd),
+	}
+	od := fd.ContainingOneof()
+	if od != nil && !od.IsSynthetic() {
+		field["oneof"] = string(od.Name())
+	} else {
+		// the scalar fields with an explicit presence, e.g. the proto3 optional fields
+		field["optional"] = fd.HasPresence() && fd.Message() == nil
+	}
+	if fd.IsMap() {
+		field["type"] = "map"
+		field["key"] = d.fieldType(fd.MapKey())
+		field["value"] = d.fieldType(fd.MapValue())
+		return field
+	}
+	for k, v := range d.fieldType(fd) {
+		field[k] = v
+	}
+	return field
+}
+
+// fieldType returns the type of the field, with the name of the message or enum it references.
+func (d describer) fieldType(fd protoreflect.FieldDescriptor) map[string]interface{} {
+	t := map[string]interface{}{"type": fd.Kind().String()}
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		t["type"] = "message"
+		t["typeName"] = string(fd.Message().FullName())
+		d.message(fd.Message())
+	case protoreflect.EnumKind:
+		t["typeName"] = string(fd.Enum().FullName())
+		d.enum(fd.Enum())
+	}
+	return t
+}
+
+func (d describer) enum(ed protoreflect.EnumDescriptor) {
+	name := string(ed.FullName())
+	if _, ok := d.enums[name]; ok {
+		return
+	}
+	values := make([]interface{}, 0, ed.Values().Len())
+	for i := 0; i < ed.Values().Len(); i++ {
+		vd := ed.Values().Get(i)
+		values = append(values, map[string]interface{}{
+			"name":    string(vd.Name()),
+			"number":  int64(vd.Number()),
+			"comment": comment(vd),
+		})
+	}
+	d.enums[name] = map[string]interface{}{
+		"name":    name,
+		"comment": comment(ed),
+		"values":  values,
+	}
+}
+
+// jsonSchema returns the JSON Schema of the message as encoded by protojson,
+// the messages it references are in its $defs.
+func (d describer) jsonSchema(md protoreflect.MessageDescriptor) map[string]interface{} {
+	defs := make(map[string]interface{})
+	schema := d.messageJSONSchema(md, defs)
+	schema["$schema"] = jsonSchemaDraft
+	schema["$defs"] = defs
+	return schema
+}
+
+func (d describer) messageJSONSchema(md protoreflect.MessageDescriptor, defs map[string]interface{}) map[string]interface{} {
+	if isWellKnownType(md) {
+		if s := wellKnownJSONSchema(md); s != nil {
+			return s
+		}
+	}
+	name := string(md.FullName())
+	ref := map[string]interface{}{"$ref": "#/$defs/" + name}
+	if _, ok := defs[name]; ok {
+		return ref
+	}
+
+	properties := make(map[string]interface{})
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"title":                name,
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if c := comment(md); c != "" {
+		schema["description"] = c
+	}
+	defs[name] = schema
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		var prop map[string]interface{}
+		switch {
+		case fd.IsMap():
+			prop = map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": d.singularJSONSchema(fd.MapValue(), defs),
+			}
+		case fd.IsList():
+			prop = map[string]interface{}{
+				"type":  "array",
+				"items": d.singularJSONSchema(fd, defs),
+			}
+		default:
+			prop = d.singularJSONSchema(fd, defs)
+		}
+		if c := comment(fd); c != "" {
+			prop["description"] = c
+		}
+		key, alias := fd.JSONName(), string(fd.Name())
+		if d.useProtoNames {
+			key, alias = alias, key
+		}
+		properties[key] = prop
+		// protojson accepts both names, the alias isn't an additional property
+		if alias != key {
+			properties[alias] = prop
+		}
+	}
+	return ref
+}
+
+func (d describer) singularJSONSchema(fd protoreflect.FieldDescriptor, defs map[string]interface{}) map[string]interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]interface{}{"type": "boolean"}
+	case protoreflect.StringKind:
+		return map[string]interface{}{"type": "string"}
+	case protoreflect.BytesKind:
+		return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]interface{}{"type": "integer"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// protojson encodes the 64-bit integers as strings
+		return map[string]interface{}{"type": []interface{}{"integer", "string"}}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return map[string]interface{}{"type": []interface{}{"number", "string"}}
+	case protoreflect.EnumKind:
+		if fd.Enum().FullName() == "google.protobuf.NullValue" {
+			return map[string]interface{}{"type": "null"}
+		}
+		values := fd.Enum().Values()
+		enum := make([]interface{}, 0, 2*values.Len())
+		for i := 0; i < values.Len(); i++ {
+			enum = append(enum, string(values.Get(i).Name()), int64(values.Get(i).Number()))
+		}
+		return map[string]interface{}{"enum": enum}
+	default:
+		return d.messageJSONSchema(fd.Message(), defs)
+	}
+}
+
+// wellKnownJSONSchema returns the schema of the well-known types with a special JSON encoding.
+func wellKnownJSONSchema(md protoreflect.MessageDescriptor) map[string]interface{} {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case "google.protobuf.Duration":
+		return map[string]interface{}{"type": "string", "pattern": `^-?[0-9]+(\.[0-9]+)?s$`}
+	case "google.protobuf.FieldMask":
+		return map[string]interface{}{"type": "string"}
+	case "google.protobuf.Struct":
+		return map[string]interface{}{"type": "object"}
+	case "google.protobuf.ListValue":
+		return map[string]interface{}{"type": "array"}
+	case "google.protobuf.Value":
+		return map[string]interface{}{}
+	case "google.protobuf.Any":
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"@type": map[string]interface{}{"type": "string"}},
+			"required":   []interface{}{"@type"},
+		}
+	case "google.protobuf.Empty":
+		return map[string]interface{}{"type": "object", "additionalProperties": false}
+	case "google.protobuf.BoolValue":
+		return map[string]interface{}{"type": []interface{}{"boolean", "null"}}
+	case "google.protobuf.StringValue":
+		return map[string]interface{}{"type": []interface{}{"string", "null"}}
+	case "google.protobuf.BytesValue":
+		return map[string]interface{}{"type": []interface{}{"string", "null"}, "contentEncoding": "base64"}
+	case "google.protobuf.Int32Value", "google.protobuf.UInt32Value":
+		return map[string]interface{}{"type": []interface{}{"integer", "null"}}
+	case "google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+		return map[string]interface{}{"type": []interface{}{"integer", "string", "null"}}
+	case "google.protobuf.FloatValue", "google.protobuf.DoubleValue":
+		return map[string]interface{}{"type": []interface{}{"number", "string", "null"}}
+	default:
+		return nil
+	}
+}
+
+// comment returns the leading comment of the descriptor, or its trailing one.
+func comment(d protoreflect.Descriptor) string {
+	file := d.ParentFile()
+	if file == nil {
+		return ""
+	}
+	loc := file.SourceLocations().ByDescriptor(d)
+	c := loc.LeadingComments
+	if strings.TrimSpace(c) == "" {
+		c = loc.TrailingComments
+	}
+	return strings.TrimSpace(c)
+}
@@ -0,0 +1,110 @@
+package grpc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/js/modulestest"
+)
+
+func TestDescribe(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{vu: &modulestest.VU{RuntimeField: sobek.New(), InitEnvField: &common.InitEnvironment{}}}
+	if _, err := c.LoadProtoString("describe.proto", `syntax = "proto3";
+package describe.test;
+import "google/protobuf/timestamp.proto";
+
+// A node of the tree.
+message Node {
+  // The node name.
+  string name = 1;
+  repeated Node children = 2;
+  map<string, int64> counters = 3;
+  oneof value {
+    string text = 4;
+    Kind kind = 5;
+  }
+  optional int32 weight = 6;
+  google.protobuf.Timestamp created_at = 7;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_LEAF = 1;
+}
+
+service Tree {
+  // Get returns a node.
+  rpc Get(Node) returns (Node);
+}
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Describe("describe.test.Tree/Unknown"); err == nil {
+		t.Fatal("expected an error for an unknown method")
+	}
+	d, err := c.Describe("describe.test.Tree/Get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d["comment"] != "Get returns a node." {
+		t.Errorf("unexpected method comment %q", d["comment"])
+	}
+
+	input := d["input"].(map[string]interface{})
+	if input["comment"] != "A node of the tree." {
+		t.Errorf("unexpected message comment %q", input["comment"])
+	}
+	fields := map[string]map[string]interface{}{}
+	for _, f := range input["fields"].([]interface{}) {
+		field := f.(map[string]interface{})
+		fields[field["name"].(string)] = field
+	}
+	if fields["name"]["comment"] != "The node name." || fields["name"]["type"] != "string" {
+		t.Errorf("unexpected name field %v", fields["name"])
+	}
+	if fields["children"]["repeated"] != true || fields["children"]["typeName"] != "describe.test.Node" {
+		t.Errorf("unexpected children field %v", fields["children"])
+	}
+	if fields["counters"]["map"] != true || !reflect.DeepEqual(fields["counters"]["value"], map[string]interface{}{"type": "int64"}) {
+		t.Errorf("unexpected counters field %v", fields["counters"])
+	}
+	if fields["kind"]["oneof"] != "value" || fields["kind"]["typeName"] != "describe.test.Kind" {
+		t.Errorf("unexpected kind field %v", fields["kind"])
+	}
+	if fields["weight"]["optional"] != true || fields["name"]["optional"] != false {
+		t.Error("only weight should be optional")
+	}
+	if oneofs := input["oneofs"].([]interface{}); len(oneofs) != 1 {
+		t.Errorf("the synthetic oneof should be left out, got %v", oneofs)
+	}
+	if _, ok := d["enums"].(map[string]interface{})["describe.test.Kind"]; !ok {
+		t.Error("the enum should be described")
+	}
+
+	schema := d["jsonSchema"].(map[string]interface{})["input"].(map[string]interface{})
+	if schema["$ref"] != "#/$defs/describe.test.Node" {
+		t.Fatalf("unexpected schema %v", schema)
+	}
+	node := schema["$defs"].(map[string]interface{})["describe.test.Node"].(map[string]interface{})
+	props := node["properties"].(map[string]interface{})
+	if !reflect.DeepEqual(props["createdAt"], map[string]interface{}{"type": "string", "format": "date-time"}) {
+		t.Errorf("unexpected createdAt schema %v", props["createdAt"])
+	}
+	if !reflect.DeepEqual(props["created_at"], props["createdAt"]) {
+		t.Errorf("the proto name should be an alias of the JSON name, got %v", props["created_at"])
+	}
+	if !reflect.DeepEqual(props["children"], map[string]interface{}{
+		"type": "array", "items": map[string]interface{}{"$ref": "#/$defs/describe.test.Node"},
+	}) {
+		t.Errorf("unexpected children schema %v", props["children"])
+	}
+	if _, err = json.Marshal(d); err != nil {
+		t.Fatal(err)
+	}
+}
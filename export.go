@@ -0,0 +1,95 @@
+package grpc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/sobek"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ExportDescriptors returns the file descriptors loaded or reflected by the client as a
+// FileDescriptorSet. The format param selects the output: "base64" (the default) for Load,
+// "protoset" for an ArrayBuffer with the binary set or "json" for its JSON encoding.
+// The files linked into the binary, such as the well-known types, are part of the set when
+// the loaded files import them, so it is self-contained.
+func (c *Client) ExportDescriptors(params map[string]interface{}) (sobek.Value, error) {
+	format := "base64"
+	for k, v := range params {
+		switch k {
+		case "format":
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid format value: '%#v', it needs to be a string", v)
+			}
+			format = s
+		default:
+			return nil, fmt.Errorf("unknown exportDescriptors param: %q", k)
+		}
+	}
+
+	fdset := c.fileDescriptorSet()
+	rt := c.vu.Runtime()
+	switch format {
+	case "base64", "protoset":
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(fdset)
+		if err != nil {
+			return nil, err
+		}
+		if format == "protoset" {
+			return rt.ToValue(rt.NewArrayBuffer(b)), nil
+		}
+		return rt.ToValue(base64.StdEncoding.EncodeToString(b)), nil
+	case "json":
+		b, err := protojson.MarshalOptions{Multiline: true}.Marshal(fdset)
+		if err != nil {
+			return nil, err
+		}
+		return rt.ToValue(string(b)), nil
+	default:
+		return nil, fmt.Errorf("invalid format %q, it needs to be base64, protoset or json", format)
+	}
+}
+
+// fileDescriptorSet returns the files of the client's registry, imports first, with the linked
+// files they import.
+func (c *Client) fileDescriptorSet() *descriptorpb.FileDescriptorSet {
+	fdset := &descriptorpb.FileDescriptorSet{}
+	files := c.base().Registry().Files()
+	var paths []string
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		paths = append(paths, fd.Path())
+		return true
+	})
+	sort.Strings(paths)
+
+	seen := make(map[string]bool, len(paths))
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			// the imports resolved with the linked files aren't in the registry
+			if imp, err := files.FindFileByPath(imports.Get(i).Path()); err == nil {
+				add(imp)
+			} else if imp := imports.Get(i).FileDescriptor; !imp.IsPlaceholder() {
+				add(imp)
+			}
+		}
+		fdset.File = append(fdset.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, path := range paths {
+		if fd, err := files.FindFileByPath(path); err == nil {
+			add(fd)
+		}
+	}
+	return fdset
+}
@@ -0,0 +1,74 @@
+package grpc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/js/modulestest"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestExportDescriptors(t *testing.T) {
+	t.Parallel()
+
+	newClient := func() *Client {
+		return &Client{vu: &modulestest.VU{RuntimeField: sobek.New(), InitEnvField: &common.InitEnvironment{}}}
+	}
+	c := newClient()
+	if _, err := c.LoadProtoString("export.proto", `syntax = "proto3";
+package export.test;
+import "google/protobuf/empty.proto";
+service Exporter { rpc Export(google.protobuf.Empty) returns (google.protobuf.Empty); }
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := c.ExportDescriptors(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods, err := newClient().Load(v.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 1 || methods[0].FullMethod != "/export.test.Exporter/Export" {
+		t.Fatalf("unexpected methods %v", methods)
+	}
+
+	v, err = c.ExportDescriptors(map[string]interface{}{"format": "protoset"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ab, ok := v.Export().(sobek.ArrayBuffer)
+	if !ok {
+		t.Fatalf("expected an ArrayBuffer, got %T", v.Export())
+	}
+	fdset := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(ab.Bytes(), fdset); err != nil {
+		t.Fatal(err)
+	}
+	// the imported linked well-known types are included, imports first
+	if len(fdset.GetFile()) != 2 || fdset.GetFile()[0].GetName() != "google/protobuf/empty.proto" ||
+		fdset.GetFile()[1].GetName() != "export.proto" {
+		t.Fatalf("unexpected files %v", fdset.GetFile())
+	}
+	if _, err = protodesc.NewFiles(fdset); err != nil {
+		t.Fatalf("the set should be self-contained: %v", err)
+	}
+
+	v, err = c.ExportDescriptors(map[string]interface{}{"format": "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := v.String(); !strings.Contains(s, `"export.proto"`) {
+		t.Fatalf("unexpected JSON %s", s)
+	}
+
+	if _, err = c.ExportDescriptors(map[string]interface{}{"format": "yaml"}); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
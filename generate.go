@@ -0,0 +1,276 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/grafana/sobek"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	defaultGenerateDepth = 3
+	maxGeneratedItems    = 3
+	maxGeneratedLength   = 16
+	generatedCharset     = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	// maxRequiredDepth bounds the required message fields set past the depth, a cycle of
+	// required fields can't be satisfied anyway.
+	maxRequiredDepth = 32
+)
+
+var generatedTimeBase = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC) //nolint:gochecknoglobals
+
+// GenerateRequest returns a random input message of the method, as a request for invoke.
+// The params are:
+//   - seed: the seed of the random values, the same seed generates the same message
+//   - depth: how deep the nested messages are populated, 3 by default, the messages required
+//     by the validation rules are populated at any depth
+//   - overrides: an object whose fields replace the generated ones, nested objects are merged
+func (c *Client) GenerateRequest(method string, params sobek.Value) (sobek.Value, error) {
+	md, err := c.methodDescriptor(method)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := c.vu.Runtime()
+	seed := time.Now().UnixNano()
+	depth := defaultGenerateDepth
+	var overrides sobek.Value
+	if !isNullish(params) {
+		obj := params.ToObject(rt)
+		for _, k := range obj.Keys() {
+			v := obj.Get(k)
+			switch k {
+			case "seed":
+				seed = v.ToInteger()
+			case "depth":
+				depth = int(v.ToInteger())
+				if depth < 0 {
+					return nil, fmt.Errorf("invalid depth value: %d, it needs to be a positive integer", depth)
+				}
+			case "overrides":
+				overrides = v
+			default:
+				return nil, fmt.Errorf("unknown generateRequest param: %q", k)
+			}
+		}
+	}
+
+	conv := c.converter(&invokeParams{JSONOptions: c.defaults.JSONOptions, TypeMapping: c.defaults.TypeMapping})
+	g := generator{
+		rnd:   rand.New(rand.NewSource(seed)), //nolint:gosec
+		rules: &constraintValidator{types: c.types(), cache: &c.constraints},
+	}
+	msg := dynamicpb.NewMessage(md.Input())
+	g.message(msg, depth)
+	if !isNullish(overrides) {
+		if err := conv.mergeFromJS(msg, overrides, string(md.Input().Name())); err != nil {
+			return nil, fmt.Errorf("invalid overrides: %w", err)
+		}
+	}
+
+	if c.defaults.TypeMapping != nil {
+		return conv.messageToJS(msg), nil
+	}
+	// encoded as the JSON invoke expects without a type mapping
+	b, err := protojson.MarshalOptions{
+		Resolver:        c.types(),
+		UseProtoNames:   c.defaults.JSONOptions.UseProtoNames,
+		UseEnumNumbers:  c.defaults.JSONOptions.UseEnumNumbers,
+		EmitUnpopulated: c.defaults.JSONOptions.EmitUnpopulated,
+	}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err = json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	return rt.ToValue(obj), nil
+}
+
+// mergeFromJS sets the fields of the JS object v in m, field by field from the object's own
+// keys, so the zero values replace the generated ones. The messages are merged and the other
+// values replaced, a null clears the field.
+func (c jsConverter) mergeFromJS(m protoreflect.Message, v sobek.Value, path string) error {
+	obj, ok := v.(*sobek.Object)
+	if !ok {
+		return fieldErrorf(path, "expected an object, got %s", v.String())
+	}
+	fields := m.Descriptor().Fields()
+	for _, k := range obj.Keys() {
+		fd := fields.ByJSONName(k)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(k))
+		}
+		if fd == nil {
+			return fieldErrorf(path, "unknown field %q", k)
+		}
+		fv := obj.Get(k)
+		if isNullish(fv) && (fd.Message() == nil || fd.Message().FullName() != "google.protobuf.Value") {
+			m.Clear(fd)
+			continue
+		}
+		_, isObject := fv.(*sobek.Object)
+		if isObject && fd.Message() != nil && !fd.IsList() && !fd.IsMap() && m.Has(fd) &&
+			!isWellKnownType(fd.Message()) {
+			if err := c.mergeFromJS(m.Mutable(fd).Message(), fv, path+"."+k); err != nil {
+				return err
+			}
+			continue
+		}
+		m.Clear(fd)
+		if err := c.setField(m, fd, fv, path+"."+k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generator populates messages with random values valid for their fields' types.
+type generator struct {
+	rnd *rand.Rand
+	// rules resolves the validation rules of the fields, to set the required ones.
+	rules *constraintValidator
+}
+
+// message sets every field, one per oneof. The message fields are set while depth is positive,
+// and past it when the validation rules require them.
+func (g generator) message(m protoreflect.Message, depth int) {
+	if g.wellKnown(m) {
+		return
+	}
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+			// the first field decides which one of the oneof is set
+			if od.Fields().Get(0) != fd {
+				continue
+			}
+			fd = od.Fields().Get(g.rnd.Intn(od.Fields().Len()))
+		}
+		if fd.Message() != nil && !fd.IsMap() && depth <= 0 && !g.required(fd, depth) {
+			continue
+		}
+
+		switch {
+		case fd.IsList():
+			list := m.Mutable(fd).List()
+			for n := 1 + g.rnd.Intn(maxGeneratedItems); n > 0; n-- {
+				list.Append(g.value(list.NewElement, fd, depth))
+			}
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil && depth <= 0 {
+				continue
+			}
+			mp := m.Mutable(fd).Map()
+			for n := 1 + g.rnd.Intn(maxGeneratedItems); n > 0; n-- {
+				key := g.scalar(fd.MapKey()).MapKey()
+				mp.Set(key, g.value(mp.NewValue, fd.MapValue(), depth))
+			}
+		default:
+			m.Set(fd, g.value(func() protoreflect.Value { return m.NewField(fd) }, fd, depth))
+		}
+	}
+}
+
+// required reports whether the validation rules require the field, the message fields past
+// the depth are only set while they are required.
+func (g generator) required(fd protoreflect.FieldDescriptor, depth int) bool {
+	if g.rules == nil || depth <= -maxRequiredDepth {
+		return false
+	}
+	rules := g.rules.rules(fd)
+	return rules != nil && required(rules)
+}
+
+// value returns a random value of the field, newValue returns an empty message for the message fields.
+func (g generator) value(
+	newValue func() protoreflect.Value,
+	fd protoreflect.FieldDescriptor,
+	depth int,
+) protoreflect.Value {
+	if fd.Message() == nil {
+		return g.scalar(fd)
+	}
+	v := newValue()
+	g.message(v.Message(), depth-1)
+	return v
+}
+
+//nolint:cyclop
+func (g generator) scalar(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(g.rnd.Intn(2) == 1)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(g.rnd.Int63n(math.MaxUint32+1) + math.MinInt32))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(g.rnd.Uint32())
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(int64(g.rnd.Uint64()))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(g.rnd.Uint64())
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(g.rnd.NormFloat64() * 1000))
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(g.rnd.NormFloat64() * 1000)
+	case protoreflect.StringKind:
+		b := make([]byte, 1+g.rnd.Intn(maxGeneratedLength))
+		for i := range b {
+			b[i] = generatedCharset[g.rnd.Intn(len(generatedCharset))]
+		}
+		return protoreflect.ValueOfString(string(b))
+	case protoreflect.BytesKind:
+		b := make([]byte, 1+g.rnd.Intn(maxGeneratedLength))
+		_, _ = g.rnd.Read(b)
+		return protoreflect.ValueOfBytes(b)
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		return protoreflect.ValueOfEnum(values.Get(g.rnd.Intn(values.Len())).Number())
+	default:
+		return fd.Default()
+	}
+}
+
+// wellKnown populates the well-known types with a special JSON encoding,
+// the ones without a sensible random value, such as Any or Struct, are left empty.
+func (g generator) wellKnown(m protoreflect.Message) bool {
+	md := m.Descriptor()
+	if !isWellKnownType(md) {
+		return false
+	}
+	fields := md.Fields()
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		// the base is fixed, so a seed always generates the same message
+		ts := generatedTimeBase.Add(time.Duration(g.rnd.Int63n(int64(10 * 365 * 24 * time.Hour))))
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(ts.Unix()))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(ts.Nanosecond())))
+	case "google.protobuf.Duration":
+		d := time.Duration(g.rnd.Int63n(int64(time.Hour)))
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(int64(d/time.Second)))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(d%time.Second)))
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value",
+		"google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		fd := fields.ByName("value")
+		m.Set(fd, g.scalar(fd))
+	case "google.protobuf.Value":
+		// a Value must have a kind, null is the only one without content
+		m.Set(fields.ByName("null_value"), protoreflect.ValueOfEnum(0))
+	case "google.protobuf.Any", "google.protobuf.Struct", "google.protobuf.ListValue", "google.protobuf.FieldMask":
+	default:
+		// the other well-known types, e.g. Empty, are regular messages
+		return false
+	}
+	return true
+}
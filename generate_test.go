@@ -0,0 +1,173 @@
+package grpc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/js/modulestest"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestGenerateRequest(t *testing.T) {
+	t.Parallel()
+
+	rt := sobek.New()
+	c := &Client{vu: &modulestest.VU{RuntimeField: rt, InitEnvField: &common.InitEnvironment{}}}
+	if _, err := c.LoadProtoString("generate.proto", `syntax = "proto3";
+package generate.test;
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/struct.proto";
+
+message Node {
+  string name = 1;
+  repeated Node children = 2;
+  map<string, int64> counters = 3;
+  oneof value {
+    string text = 4;
+    Kind kind = 5;
+  }
+  bytes data = 6;
+  double score = 7;
+  uint32 size = 8;
+  google.protobuf.Timestamp created_at = 9;
+  google.protobuf.Value extra = 10;
+  bool enabled = 11;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_LEAF = 1;
+}
+
+service Tree { rpc Put(Node) returns (Node); }
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	generate := func(params map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		v, err := c.GenerateRequest("generate.test.Tree/Put", rt.ToValue(params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.Export().(map[string]interface{})
+	}
+
+	first := generate(map[string]interface{}{"seed": 42})
+	if second := generate(map[string]interface{}{"seed": 42}); !reflect.DeepEqual(first, second) {
+		t.Fatalf("the same seed should generate the same message:\n%v\n%v", first, second)
+	}
+	_, hasText := first["text"]
+	_, hasKind := first["kind"]
+	if hasText == hasKind {
+		t.Fatalf("exactly one field of the oneof should be set: %v", first)
+	}
+	if children, ok := first["children"].([]interface{}); !ok || len(children) == 0 {
+		t.Fatalf("the children should be populated: %v", first)
+	}
+
+	// the generated message is a valid request
+	b, err := json.Marshal(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, err := c.base().Method("/generate.test.Tree/Put")
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := method.Input()
+	if err = (protojson.UnmarshalOptions{Resolver: c.types()}).Unmarshal(b, dynamicpb.NewMessage(md)); err != nil {
+		t.Fatalf("invalid generated message %s: %v", b, err)
+	}
+
+	flat := generate(map[string]interface{}{"seed": 1, "depth": 0})
+	if _, ok := flat["children"]; ok {
+		t.Fatalf("no nested message should be generated with depth 0: %v", flat)
+	}
+
+	overridden := generate(map[string]interface{}{
+		"seed":      42,
+		"overrides": map[string]interface{}{"name": "fixed", "size": 7},
+	})
+	if overridden["name"] != "fixed" || overridden["size"] != float64(7) {
+		t.Fatalf("the overrides should be applied: %v", overridden)
+	}
+	if !reflect.DeepEqual(overridden["children"], first["children"]) {
+		t.Fatal("the other fields should be kept")
+	}
+
+	// the zero values replace the generated ones
+	zeroed := generate(map[string]interface{}{
+		"seed":      42,
+		"overrides": map[string]interface{}{"name": "", "size": 0, "enabled": false, "score": 0},
+	})
+	for _, k := range []string{"name", "size", "enabled", "score"} {
+		if v, ok := zeroed[k]; ok {
+			t.Errorf("%s should be overridden with its zero value, got %v", k, v)
+		}
+	}
+	if !reflect.DeepEqual(zeroed["children"], first["children"]) {
+		t.Fatal("the other fields should be kept")
+	}
+
+	if _, err = c.GenerateRequest("generate.test.Tree/Put", rt.ToValue(map[string]interface{}{"depth": -1})); err == nil {
+		t.Fatal("expected an error for a negative depth")
+	}
+	if _, err = c.GenerateRequest("generate.test.Tree/Put", rt.ToValue(map[string]interface{}{"unknown": 1})); err == nil {
+		t.Fatal("expected an error for an unknown param")
+	}
+}
+
+func TestGenerateRequiredFields(t *testing.T) {
+	t.Parallel()
+
+	fdset, err := compileProtos(&protocompile.SourceResolver{
+		Accessor: protocompile.SourceAccessorFromMap(map[string]string{
+			"buf/validate/validate.proto": validateTestRulesProto,
+			"val.proto":                   validateTestProto,
+		}),
+	}, "val.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := sobek.New()
+	c := &Client{vu: &modulestest.VU{RuntimeField: rt}}
+	if _, err = c.convertToMethodInfo(fdset); err != nil {
+		t.Fatal(err)
+	}
+	method, err := c.base().Method("/val.Orders/Place")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the required message fields are populated past the depth
+	v, err := c.GenerateRequest("val.Orders/Place", rt.ToValue(map[string]interface{}{"seed": 1, "depth": 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := v.Export().(map[string]interface{})
+	if _, ok := generated["item"]; !ok {
+		t.Fatalf("the required item should be generated: %v", generated)
+	}
+	if _, ok := generated["items"]; ok {
+		t.Fatalf("the other message fields shouldn't be generated with depth 0: %v", generated)
+	}
+	b, err := json.Marshal(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := dynamicpb.NewMessage(method.Input())
+	if err = (protojson.UnmarshalOptions{Resolver: c.types()}).Unmarshal(b, msg); err != nil {
+		t.Fatal(err)
+	}
+	for _, violation := range validateConstraints(msg, c.types(), nil, false) {
+		if violation.Rule == ruleRequired {
+			t.Errorf("unexpected violation %+v", violation)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shlsky/xk6-grpc/xgrpc_conn"
+	"go.k6.io/k6/lib/types"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// healthParams are the params of the health requests.
+type healthParams struct {
+	Metadata metadata.MD
+	Timeout  time.Duration
+	// Until is the status ending watchHealth, SERVING by default.
+	Until xgrpc_conn.HealthStatus
+}
+
+func parseHealthParams(raw map[string]interface{}, watch bool) (healthParams, error) {
+	params := healthParams{Until: healthpb.HealthCheckResponse_SERVING}
+	for k, v := range raw {
+		switch k {
+		case "metadata":
+			rawMD, ok := v.(map[string]interface{})
+			if !ok {
+				return params, errors.New("metadata must be an object with key-value pairs")
+			}
+			md, err := parseMetadata(rawMD)
+			if err != nil {
+				return params, err
+			}
+			params.Metadata = md
+		case "timeout":
+			var err error
+			params.Timeout, err = types.GetDurationValue(v)
+			if err != nil {
+				return params, fmt.Errorf("invalid timeout value: %w", err)
+			}
+		case "until":
+			if !watch {
+				return params, fmt.Errorf("unknown health param: %q", k)
+			}
+			var err error
+			params.Until, err = parseHealthStatus(v)
+			if err != nil {
+				return params, err
+			}
+		default:
+			return params, fmt.Errorf("unknown health param: %q", k)
+		}
+	}
+	return params, nil
+}
+
+// parseHealthStatus accepts the name or the number of a serving status.
+func parseHealthStatus(v interface{}) (xgrpc_conn.HealthStatus, error) {
+	switch val := v.(type) {
+	case string:
+		if n, ok := healthpb.HealthCheckResponse_ServingStatus_value[val]; ok {
+			return xgrpc_conn.HealthStatus(n), nil
+		}
+	case int64:
+		if _, ok := healthpb.HealthCheckResponse_ServingStatus_name[int32(val)]; ok {
+			return xgrpc_conn.HealthStatus(val), nil
+		}
+	case xgrpc_conn.HealthStatus:
+		return val, nil
+	}
+	return 0, fmt.Errorf("invalid until value: '%#v', it needs to be a serving status", v)
+}
+
+// HealthCheck returns the serving status of the service with grpc.health.v1, the overall status
+// of the server for an empty service. The params can set the metadata and the timeout of the request.
+func (c *Client) HealthCheck(service string, params map[string]interface{}) (xgrpc_conn.HealthStatus, error) {
+	if c.base().Conn() == nil {
+		return 0, errors.New("no gRPC connection, you must call connect first")
+	}
+	p, err := parseHealthParams(params, false)
+	if err != nil {
+		return 0, fmt.Errorf("invalid grpc.healthCheck() parameters: %w", err)
+	}
+
+	ctx, cancel := c.requestContext(p.Timeout, p.Metadata)
+	defer cancel()
+	return c.base().Conn().HealthCheck(ctx, service)
+}
+
+// WatchHealth watches the serving status of the service with grpc.health.v1 until it is the until
+// param, SERVING by default, and returns the statuses sent by the server. When the timeout elapses
+// first, the statuses received so far are returned, so the last one tells the current status.
+func (c *Client) WatchHealth(service string, params map[string]interface{}) ([]xgrpc_conn.HealthStatus, error) {
+	if c.base().Conn() == nil {
+		return nil, errors.New("no gRPC connection, you must call connect first")
+	}
+	p, err := parseHealthParams(params, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grpc.watchHealth() parameters: %w", err)
+	}
+
+	ctx, cancel := c.requestContext(p.Timeout, p.Metadata)
+	defer cancel()
+
+	statuses := []xgrpc_conn.HealthStatus{}
+	err = c.base().Conn().WatchHealth(ctx, service, func(s xgrpc_conn.HealthStatus) bool {
+		statuses = append(statuses, s)
+		return s != p.Until
+	})
+	if err != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return statuses, err
+	}
+	return statuses, nil
+}
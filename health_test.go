@@ -0,0 +1,47 @@
+package grpc
+
+import (
+	"testing"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestParseHealthParams(t *testing.T) {
+	t.Parallel()
+
+	p, err := parseHealthParams(map[string]interface{}{"until": "NOT_SERVING", "timeout": "1s"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Until != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("unexpected until %s", p.Until)
+	}
+	if p, err = parseHealthParams(nil, true); err != nil || p.Until != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("until should be SERVING by default, got %s %v", p.Until, err)
+	}
+
+	for _, raw := range []map[string]interface{}{
+		{"until": "READY"},
+		{"until": int64(9)},
+		{"metadata": "x"},
+		{"unknown": true},
+	} {
+		if _, err = parseHealthParams(raw, true); err == nil {
+			t.Errorf("%v: expected an error", raw)
+		}
+	}
+	if _, err = parseHealthParams(map[string]interface{}{"until": "SERVING"}, false); err == nil {
+		t.Error("until should only be accepted by watchHealth")
+	}
+
+	cp, err := parseConnectParams(map[string]interface{}{"healthCheck": "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.HealthCheck || cp.HealthCheckService != "svc" {
+		t.Fatalf("a service name should enable the health checking, got %v %q", cp.HealthCheck, cp.HealthCheckService)
+	}
+	if _, err = parseConnectParams(map[string]interface{}{"healthCheck": int64(1)}); err == nil {
+		t.Error("expected an error for a number")
+	}
+}
@@ -2,7 +2,9 @@ package grpc
 
 import (
 	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/metrics"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	gresolver "google.golang.org/grpc/resolver"
 )
 
@@ -23,6 +25,14 @@ type (
 	ModuleInstance struct {
 		vu      modules.VU
 		exports map[string]interface{}
+		metrics instanceMetrics
+	}
+
+	// instanceMetrics are the custom metrics of the module.
+	instanceMetrics struct {
+		reqInvalid           *metrics.Metric
+		connStateTransitions *metrics.Metric
+		connStateDuration    *metrics.Metric
 	}
 )
 
@@ -43,6 +53,13 @@ func (*RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
 		vu:      vu,
 		exports: make(map[string]interface{}),
 	}
+	if initEnv := vu.InitEnv(); initEnv != nil && initEnv.Registry != nil {
+		mi.metrics = instanceMetrics{
+			reqInvalid:           initEnv.Registry.MustNewMetric("grpc_req_invalid", metrics.Counter),
+			connStateTransitions: initEnv.Registry.MustNewMetric("grpc_conn_state_transitions", metrics.Counter),
+			connStateDuration:    initEnv.Registry.MustNewMetric("grpc_conn_state_duration", metrics.Trend, metrics.Time),
+		}
+	}
 
 	mi.exports["Client"] = mi.NewClient
 	mi.exports["Util"] = mi.NewUtil
@@ -74,6 +91,14 @@ func (mi *ModuleInstance) defineConstants() {
 	mustAddCode("StatusUnavailable", codes.Unavailable)
 	mustAddCode("StatusDataLoss", codes.DataLoss)
 	mustAddCode("StatusUnauthenticated", codes.Unauthenticated)
+
+	mustAddHealthStatus := func(name string, status healthpb.HealthCheckResponse_ServingStatus) {
+		mi.exports[name] = rt.ToValue(status)
+	}
+	mustAddHealthStatus("HealthStatusUnknown", healthpb.HealthCheckResponse_UNKNOWN)
+	mustAddHealthStatus("HealthStatusServing", healthpb.HealthCheckResponse_SERVING)
+	mustAddHealthStatus("HealthStatusNotServing", healthpb.HealthCheckResponse_NOT_SERVING)
+	mustAddHealthStatus("HealthStatusServiceUnknown", healthpb.HealthCheckResponse_SERVICE_UNKNOWN)
 }
 
 // Exports returns the exports of the grpc module.
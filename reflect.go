@@ -0,0 +1,139 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.k6.io/k6/lib/types"
+	"google.golang.org/grpc/metadata"
+)
+
+// reflectParams are the params of the reflection requests.
+type reflectParams struct {
+	// Services are the services to resolve, all the services of the server when empty.
+	Services []string
+	Metadata metadata.MD
+	Timeout  time.Duration
+}
+
+func parseReflectParams(raw map[string]interface{}) (reflectParams, error) {
+	var params reflectParams
+	for k, v := range raw {
+		switch k {
+		case "services":
+			list, ok := v.([]interface{})
+			if !ok {
+				return params, fmt.Errorf("invalid services value: '%#v', it needs to be an array of strings", v)
+			}
+			for _, item := range list {
+				name, ok := item.(string)
+				if !ok || name == "" {
+					return params, fmt.Errorf("invalid service name: '%#v', it needs to be a non-empty string", item)
+				}
+				params.Services = append(params.Services, name)
+			}
+		case "metadata":
+			rawMD, ok := v.(map[string]interface{})
+			if !ok {
+				return params, errors.New("metadata must be an object with key-value pairs")
+			}
+			md, err := parseMetadata(rawMD)
+			if err != nil {
+				return params, err
+			}
+			params.Metadata = md
+		case "timeout":
+			var err error
+			params.Timeout, err = types.GetDurationValue(v)
+			if err != nil {
+				return params, fmt.Errorf("invalid timeout value: %w", err)
+			}
+		default:
+			return params, fmt.Errorf("unknown reflect param: %q", k)
+		}
+	}
+	return params, nil
+}
+
+// reflectContext returns the context of a reflection request.
+func (c *Client) reflectContext(p reflectParams) (context.Context, context.CancelFunc) {
+	return c.requestContext(p.Timeout, p.Metadata)
+}
+
+// requestContext returns the context of a request made by the client outside of invoke,
+// its metadata are the default metadata of the client replaced by the given ones.
+func (c *Client) requestContext(timeout time.Duration, reqMD metadata.MD) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		timeout = c.defaults.Timeout
+	}
+	if timeout == 0 {
+		timeout = defaultInvokeTimeout
+	}
+	ctx, cancel := context.WithTimeout(c.vu.Context(), timeout)
+
+	md := c.defaults.Metadata.Copy()
+	for k, v := range reqMD {
+		md[k] = v
+	}
+	if len(md) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	}
+	return ctx, cancel
+}
+
+// reflect resolves the services using the reflection and makes their methods available to request.
+func (c *Client) reflect(p reflectParams) ([]MethodInfo, error) {
+	ctx, cancel := c.reflectContext(p)
+	defer cancel()
+
+	fdset, err := c.base().Conn().Reflect(ctx, p.Services...)
+	if err != nil {
+		return nil, err
+	}
+	methods, err := c.convertToMethodInfo(fdset)
+	if err != nil {
+		return nil, fmt.Errorf("can't convert method info: %w", err)
+	}
+	return methods, nil
+}
+
+// ListServices returns the names of the services exposed by the server, using the reflection.
+// The params can set the metadata and the timeout of the request.
+func (c *Client) ListServices(params map[string]interface{}) ([]string, error) {
+	if c.base().Conn() == nil {
+		return nil, errors.New("no gRPC connection, you must call connect first")
+	}
+	p, err := parseReflectParams(params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grpc.listServices() parameters: %w", err)
+	}
+	if len(p.Services) > 0 {
+		return nil, errors.New("invalid grpc.listServices() parameters: services can't be set")
+	}
+
+	ctx, cancel := c.reflectContext(p)
+	defer cancel()
+	return c.base().Conn().ListServices(ctx)
+}
+
+// Reflect resolves the service using the reflection and makes its methods available to request.
+// The params can set the metadata and the timeout of the request.
+func (c *Client) Reflect(service string, params map[string]interface{}) ([]MethodInfo, error) {
+	if c.base().Conn() == nil {
+		return nil, errors.New("no gRPC connection, you must call connect first")
+	}
+	if service == "" {
+		return nil, errors.New("service to reflect cannot be empty")
+	}
+	p, err := parseReflectParams(params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grpc.reflect() parameters: %w", err)
+	}
+	if len(p.Services) > 0 {
+		return nil, errors.New("invalid grpc.reflect() parameters: services can't be set")
+	}
+	p.Services = []string{service}
+	return c.reflect(p)
+}
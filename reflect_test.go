@@ -0,0 +1,56 @@
+package grpc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseReflectParams(t *testing.T) {
+	t.Parallel()
+
+	p, err := parseConnectParams(map[string]interface{}{
+		"timeout": "5s",
+		"reflect": map[string]interface{}{
+			"services": []interface{}{"pkg.A", "pkg.B"},
+			"metadata": map[string]interface{}{"authorization": "Bearer token"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.UseReflectionProtocol {
+		t.Fatal("an object should enable the reflection")
+	}
+	if !reflect.DeepEqual(p.Reflect.Services, []string{"pkg.A", "pkg.B"}) {
+		t.Fatalf("unexpected services %v", p.Reflect.Services)
+	}
+	if got := p.Reflect.Metadata.Get("authorization"); len(got) != 1 || got[0] != "Bearer token" {
+		t.Fatalf("unexpected metadata %v", p.Reflect.Metadata)
+	}
+	if p.Reflect.Timeout != 5*time.Second {
+		t.Fatalf("the reflection should use the connect timeout, got %s", p.Reflect.Timeout)
+	}
+
+	p, err = parseConnectParams(map[string]interface{}{"reflect": map[string]interface{}{"timeout": "2s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Reflect.Timeout != 2*time.Second {
+		t.Fatalf("unexpected timeout %s", p.Reflect.Timeout)
+	}
+
+	for _, raw := range []map[string]interface{}{
+		{"services": "pkg.A"},
+		{"services": []interface{}{""}},
+		{"metadata": "x"},
+		{"unknown": true},
+	} {
+		if _, err = parseConnectParams(map[string]interface{}{"reflect": raw}); err == nil {
+			t.Errorf("%v: expected an error", raw)
+		}
+	}
+	if _, err = parseConnectParams(map[string]interface{}{"reflect": "yes"}); err == nil {
+		t.Error("expected an error for a string")
+	}
+}
@@ -0,0 +1,88 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/grafana/sobek"
+	"github.com/shlsky/xk6-grpc/xgrpc_conn"
+)
+
+// parseSigningParams returns the request signer of the signing param,
+// null or false disables the signing set by the defaults.
+func parseSigningParams(v interface{}) (*xgrpc_conn.Signer, error) {
+	if v == nil || v == false {
+		return nil, nil //nolint:nilnil
+	}
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid signing value: '%#v', it needs to be an object", v)
+	}
+	s := &xgrpc_conn.Signer{Separator: "\n"}
+	for k, v := range raw {
+		var err error
+		switch k {
+		case "algorithm":
+			s.Algorithm, err = stringParam(k, v)
+		case "secret":
+			switch val := v.(type) {
+			case string:
+				s.Secret = []byte(val)
+			case sobek.ArrayBuffer:
+				s.Secret = val.Bytes()
+			case []byte:
+				s.Secret = val
+			default:
+				err = fmt.Errorf("invalid secret value: '%#v', it needs to be a string or an ArrayBuffer", v)
+			}
+		case "apiKey":
+			s.APIKey, err = stringParam(k, v)
+		case "layout":
+			s.Layout, err = stringsParam(k, v)
+		case "separator":
+			s.Separator, err = stringParam(k, v)
+		case "body":
+			s.Body, err = stringParam(k, v)
+		case "encoding":
+			s.Encoding, err = stringParam(k, v)
+		case "timestampFormat":
+			s.TimestampFormat, err = stringParam(k, v)
+		case "metadata":
+			err = parseSigningMetadata(s, v)
+		default:
+			err = fmt.Errorf("unknown signing param: %q", k)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseSigningMetadata sets the metadata keys of the signature, the timestamp and the API key.
+func parseSigningMetadata(s *xgrpc_conn.Signer, v interface{}) error {
+	keys, ok := v.(map[string]interface{})
+	if !ok {
+		return errors.New("signing metadata must be an object with the signature, timestamp and apiKey keys")
+	}
+	for k, v := range keys {
+		key, err := stringParam(k, v)
+		if err != nil {
+			return err
+		}
+		switch k {
+		case "signature":
+			s.SignatureKey = key
+		case "timestamp":
+			s.TimestampKey = key
+		case "apiKey":
+			s.APIKeyKey = key
+		default:
+			return fmt.Errorf("unknown signing metadata: %q", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package grpc
+
+import "testing"
+
+func TestParseSigningParams(t *testing.T) {
+	t.Parallel()
+
+	s, err := parseSigningParams(map[string]interface{}{
+		"algorithm": "sha512",
+		"secret":    "s3cret",
+		"apiKey":    "key-1",
+		"layout":    []interface{}{"timestamp", "method", "body"},
+		"body":      "json",
+		"metadata":  map[string]interface{}{"signature": "X-Sign", "timestamp": "X-Ts"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(s.Secret) != "s3cret" || s.Separator != "\n" || s.Layout[0] != "timestamp" ||
+		s.SignatureKey != "X-Sign" || s.TimestampKey != "X-Ts" {
+		t.Fatalf("unexpected signer %+v", s)
+	}
+	if s, err = parseSigningParams(false); err != nil || s != nil {
+		t.Fatalf("false should disable the signing, got %+v %v", s, err)
+	}
+
+	for _, raw := range []interface{}{
+		"secret",
+		map[string]interface{}{"apiKey": "key-1"},
+		map[string]interface{}{"secret": "s", "algorithm": "md5"},
+		map[string]interface{}{"secret": "s", "layout": []interface{}{"path"}},
+		map[string]interface{}{"secret": "s", "metadata": map[string]interface{}{"nonce": "x-nonce"}},
+		map[string]interface{}{"secret": "s", "unknown": true},
+	} {
+		if _, err = parseSigningParams(raw); err == nil {
+			t.Errorf("%v: expected an error", raw)
+		}
+	}
+}
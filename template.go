@@ -0,0 +1,409 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/sobek"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Template is a request message compiled once, usually in the init context,
+// whose placeholder fields are the only ones patched on every invoke.
+//
+// A placeholder is written as {{name}} inside a JSON string value, name is either
+// a variable given to invoke or one of the built-in generators:
+// uuid, seq, nowNanos, nowMicros, nowMillis, randInt(min,max) and randString(length).
+// The value of a bytes field is base64-decoded as in JSON, unless its variable is an ArrayBuffer.
+type Template struct {
+	method   string
+	md       protoreflect.MethodDescriptor
+	skeleton *dynamicpb.Message
+	fields   []templateField
+	seq      int64
+}
+
+// templateField is a field of the message holding at least one placeholder.
+type templateField struct {
+	fd    protoreflect.FieldDescriptor
+	path  []templateStep
+	parts []templatePart
+}
+
+// templateStep is a step from the root message to a placeholder field,
+// key is set for map entries and index for list elements.
+type templateStep struct {
+	fd    protoreflect.FieldDescriptor
+	index int
+	key   protoreflect.MapKey
+}
+
+// templatePart is either a literal text or a placeholder of a string value.
+type templatePart struct {
+	literal string
+	name    string
+	gen     func(t *Template) interface{}
+}
+
+var placeholderRegexp = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// Template compiles the given request (a JSON string or an object) containing
+// placeholders into a Template that can be passed to invoke instead of the method.
+func (c *Client) Template(method string, req sobek.Value) (*Template, error) {
+	if method == "" {
+		return nil, errors.New("method to invoke cannot be empty")
+	}
+	if method[0] != '/' {
+		method = "/" + method
+	}
+	methodDesc, err := c.base().Method(method)
+	if err != nil {
+		return nil, err
+	}
+	if req == nil || sobek.IsUndefined(req) || sobek.IsNull(req) {
+		return nil, errors.New("request cannot be nil")
+	}
+
+	var raw []byte
+	if s, ok := req.Export().(string); ok {
+		raw = []byte(s)
+	} else {
+		var err error
+		if raw, err = req.ToObject(c.vu.Runtime()).MarshalJSON(); err != nil {
+			return nil, fmt.Errorf("unable to serialise request object: %w", err)
+		}
+	}
+
+	opts := protojson.UnmarshalOptions{Resolver: c.types(), DiscardUnknown: c.defaults.JSONOptions.DiscardUnknown}
+	tpl, err := compileTemplate(methodDesc, raw, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template for %q: %w", method, err)
+	}
+	tpl.method = method
+	return tpl, nil
+}
+
+func compileTemplate(md protoreflect.MethodDescriptor, raw []byte, opts protojson.UnmarshalOptions) (*Template, error) {
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	t := &Template{md: md}
+	if err := t.walkMessage(md.Input(), doc, nil); err != nil {
+		return nil, err
+	}
+
+	// the placeholders have been replaced with zero values,
+	// so the skeleton holds every constant field of the request
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	t.skeleton = dynamicpb.NewMessage(md.Input())
+	if err = opts.Unmarshal(b, t.skeleton); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Template) walkMessage(desc protoreflect.MessageDescriptor, obj map[string]interface{}, path []templateStep) error {
+	fields := desc.Fields()
+	// sorted so the generators are always called in the same order
+	for _, k := range sortedKeys(obj) {
+		v := obj[k]
+		fd := fields.ByJSONName(k)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(k))
+		}
+		if fd == nil {
+			// protojson reports the unknown fields
+			continue
+		}
+
+		switch {
+		case fd.IsMap():
+			entries, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, mk := range sortedKeys(entries) {
+				mv := entries[mk]
+				key, err := parseMapKey(fd.MapKey(), mk)
+				if err != nil {
+					return err
+				}
+				step := templateStep{fd: fd, key: key}
+				if entries[mk], err = t.walkValue(fd.MapValue(), mv, appendStep(path, step)); err != nil {
+					return err
+				}
+			}
+		case fd.IsList():
+			elems, ok := v.([]interface{})
+			if !ok {
+				continue
+			}
+			for i, ev := range elems {
+				var err error
+				if elems[i], err = t.walkValue(fd, ev, appendStep(path, templateStep{fd: fd, index: i})); err != nil {
+					return err
+				}
+			}
+		default:
+			var err error
+			if obj[k], err = t.walkValue(fd, v, appendStep(path, templateStep{fd: fd})); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Template) walkValue(fd protoreflect.FieldDescriptor, v interface{}, path []templateStep) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		if !placeholderRegexp.MatchString(val) {
+			return v, nil
+		}
+		if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+			return nil, fmt.Errorf("field %q: placeholders are only supported on scalar fields", fd.FullName())
+		}
+		parts, err := parseTemplateParts(val)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", fd.FullName(), err)
+		}
+		t.fields = append(t.fields, templateField{fd: fd, path: path, parts: parts})
+		return zeroJSONValue(fd), nil
+	case map[string]interface{}:
+		if fd.Kind() == protoreflect.MessageKind && !isWellKnownType(fd.Message()) {
+			return val, t.walkMessage(fd.Message(), val, path)
+		}
+	}
+	return v, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// appendStep returns a new path so the paths of sibling fields don't share the backing array.
+func appendStep(path []templateStep, step templateStep) []templateStep {
+	result := make([]templateStep, 0, len(path)+1)
+	return append(append(result, path...), step)
+}
+
+func parseTemplateParts(s string) ([]templatePart, error) {
+	var parts []templatePart
+	last := 0
+	for _, loc := range placeholderRegexp.FindAllStringSubmatchIndex(s, -1) {
+		if loc[0] > last {
+			parts = append(parts, templatePart{literal: s[last:loc[0]]})
+		}
+		part, err := parsePlaceholder(s[loc[2]:loc[3]])
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+		last = loc[1]
+	}
+	if last < len(s) {
+		parts = append(parts, templatePart{literal: s[last:]})
+	}
+	return parts, nil
+}
+
+func parsePlaceholder(expr string) (templatePart, error) {
+	name, rawArgs, hasArgs := strings.Cut(expr, "(")
+	name = strings.TrimSpace(name)
+	var args []int64
+	if hasArgs {
+		rawArgs, ok := strings.CutSuffix(strings.TrimSpace(rawArgs), ")")
+		if !ok {
+			return templatePart{}, fmt.Errorf("invalid placeholder %q", expr)
+		}
+		for _, a := range strings.Split(rawArgs, ",") {
+			n, err := strconv.ParseInt(strings.TrimSpace(a), 10, 64)
+			if err != nil {
+				return templatePart{}, fmt.Errorf("invalid argument of placeholder %q: %w", expr, err)
+			}
+			args = append(args, n)
+		}
+	}
+
+	gen, err := templateGenerator(name, args)
+	if err != nil {
+		return templatePart{}, fmt.Errorf("invalid placeholder %q: %w", expr, err)
+	}
+	if gen == nil && hasArgs {
+		return templatePart{}, fmt.Errorf("unknown generator %q", name)
+	}
+	return templatePart{name: name, gen: gen}, nil
+}
+
+// templateGenerator returns the built-in generator with the given name,
+// or nil when name refers to a variable.
+func templateGenerator(name string, args []int64) (func(t *Template) interface{}, error) {
+	expectArgs := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s expects %d arguments, got %d", name, n, len(args))
+		}
+		return nil
+	}
+
+	switch name {
+	case "uuid":
+		return func(*Template) interface{} { return uuid.NewString() }, expectArgs(0)
+	case "seq":
+		return func(t *Template) interface{} { t.seq++; return t.seq }, expectArgs(0)
+	case "nowNanos":
+		return func(*Template) interface{} { return time.Now().UnixNano() }, expectArgs(0)
+	case "nowMicros":
+		return func(*Template) interface{} { return time.Now().UnixMicro() }, expectArgs(0)
+	case "nowMillis":
+		return func(*Template) interface{} { return time.Now().UnixMilli() }, expectArgs(0)
+	case "randInt":
+		if err := expectArgs(2); err != nil {
+			return nil, err
+		}
+		lo, hi := args[0], args[1]
+		if hi < lo {
+			return nil, errors.New("randInt max must not be lower than min")
+		}
+		return func(*Template) interface{} { return lo + rand.Int63n(hi-lo+1) }, nil //nolint:gosec
+	case "randString":
+		if err := expectArgs(1); err != nil {
+			return nil, err
+		}
+		n := args[0]
+		if n < 0 {
+			return nil, errors.New("randString length must not be negative")
+		}
+		return func(*Template) interface{} { return randomString(int(n)) }, nil
+	default:
+		return nil, nil
+	}
+}
+
+const randomStringLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = randomStringLetters[rand.Intn(len(randomStringLetters))] //nolint:gosec
+	}
+	return string(b)
+}
+
+// build returns a copy of the skeleton with the placeholders replaced.
+func (t *Template) build(vars map[string]interface{}) (proto.Message, error) {
+	msg := proto.Clone(t.skeleton).ProtoReflect()
+	for _, f := range t.fields {
+		v, err := f.value(t, vars)
+		if err != nil {
+			return nil, err
+		}
+		// a placeholder is the text of a JSON string, whatever the type of its value
+		if _, ok := v.(string); !ok && f.fd.Kind() == protoreflect.StringKind {
+			v = fmt.Sprint(v)
+		}
+		pv, err := toProtoScalar(f.fd, v)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.fd.FullName(), err)
+		}
+		f.set(msg, pv)
+	}
+	return msg.Interface(), nil
+}
+
+// value returns the value of a single placeholder as is, so it keeps its type,
+// and the concatenation of the parts in any other case.
+func (f templateField) value(t *Template, vars map[string]interface{}) (interface{}, error) {
+	if len(f.parts) == 1 {
+		return f.parts[0].value(t, vars)
+	}
+	var sb strings.Builder
+	for _, p := range f.parts {
+		v, err := p.value(t, vars)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := v.(string); ok {
+			sb.WriteString(s)
+		} else {
+			fmt.Fprint(&sb, v)
+		}
+	}
+	return sb.String(), nil
+}
+
+func (p templatePart) value(t *Template, vars map[string]interface{}) (interface{}, error) {
+	switch {
+	case p.gen != nil:
+		return p.gen(t), nil
+	case p.name == "":
+		return p.literal, nil
+	}
+	v, ok := vars[p.name]
+	if !ok {
+		return nil, fmt.Errorf("template variable %q is not set", p.name)
+	}
+	return v, nil
+}
+
+func (f templateField) set(msg protoreflect.Message, v protoreflect.Value) {
+	for i, step := range f.path {
+		last := i == len(f.path)-1
+		switch {
+		case step.fd.IsList():
+			list := msg.Mutable(step.fd).List()
+			if last {
+				list.Set(step.index, v)
+				return
+			}
+			msg = list.Get(step.index).Message()
+		case step.fd.IsMap():
+			entries := msg.Mutable(step.fd).Map()
+			if last {
+				entries.Set(step.key, v)
+				return
+			}
+			msg = entries.Mutable(step.key).Message()
+		default:
+			if last {
+				msg.Set(step.fd, v)
+				return
+			}
+			msg = msg.Mutable(step.fd).Message()
+		}
+	}
+}
+
+func zeroJSONValue(fd protoreflect.FieldDescriptor) interface{} {
+	switch fd.Kind() {
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		return ""
+	case protoreflect.BoolKind:
+		return false
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,121 @@
+package grpc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafana/sobek"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const templateTestProto = `syntax = "proto3";
+package tpl;
+
+enum Side {
+  BUY = 0;
+  SELL = 1;
+}
+
+message Item {
+  string sku = 1;
+  int64 qty = 2;
+}
+
+message Order {
+  string id = 1;
+  int64 user_id = 2;
+  Side side = 3;
+  string note = 4;
+  repeated Item items = 5;
+  map<string, uint32> limits = 6;
+  int64 seq = 7;
+  bytes payload = 8;
+}
+
+service Orders {
+  rpc Place(Order) returns (Order);
+}
+`
+
+func TestTemplate(t *testing.T) {
+	fd := parseTestProto(t, templateTestProto)
+	md := fd.Services().Get(0).Methods().Get(0)
+
+	tpl, err := compileTemplate(md, []byte(`{
+		"id": "{{uuid}}",
+		"userId": "{{user}}",
+		"side": "{{side}}",
+		"note": "order {{seq}} of {{user}}",
+		"items": [{"sku": "fixed", "qty": 1}, {"sku": "{{sku}}", "qty": "{{randInt(1,3)}}"}],
+		"limits": {"daily": "{{limit}}"},
+		"seq": "{{seq}}"
+	}`), protojson.UnmarshalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]interface{}{"user": int64(42), "side": "SELL", "sku": "abc", "limit": "7"}
+	for i := 1; i <= 2; i++ {
+		msg, err := tpl.build(vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := msg.ProtoReflect()
+		fields := m.Descriptor().Fields()
+		get := func(name string) protoreflect.Value { return m.Get(fields.ByName(protoreflect.Name(name))) }
+
+		if id := get("id").String(); len(id) != 36 {
+			t.Errorf("expected a uuid, got %q", id)
+		}
+		if get("user_id").Int() != 42 || get("side").Enum() != 1 {
+			t.Errorf("unexpected user_id/side %v/%v", get("user_id"), get("side"))
+		}
+		if note := get("note").String(); !strings.HasSuffix(note, " of 42") {
+			t.Errorf("unexpected note %q", note)
+		}
+		items := get("items").List()
+		first, second := items.Get(0).Message(), items.Get(1).Message()
+		itemFields := first.Descriptor().Fields()
+		if first.Get(itemFields.ByName("sku")).String() != "fixed" || second.Get(itemFields.ByName("sku")).String() != "abc" {
+			t.Errorf("unexpected items %v", items)
+		}
+		if qty := second.Get(itemFields.ByName("qty")).Int(); qty < 1 || qty > 3 {
+			t.Errorf("randInt out of range: %d", qty)
+		}
+		if limit := get("limits").Map().Get(protoreflect.ValueOfString("daily").MapKey()); limit.Uint() != 7 {
+			t.Errorf("unexpected limit %v", limit)
+		}
+		// seq is incremented by each placeholder using it
+		if seq := get("seq").Int(); seq != int64(2*i) {
+			t.Errorf("expected seq %d, got %d", 2*i, seq)
+		}
+	}
+
+	if _, err = tpl.build(map[string]interface{}{"user": int64(1)}); err == nil {
+		t.Error("expected an error for a missing variable")
+	}
+
+	// a bytes placeholder is base64-decoded as in JSON, an ArrayBuffer is used as is
+	tpl, err = compileTemplate(md, []byte(`{"payload": "{{payload}}"}`), protojson.UnmarshalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := sobek.New()
+	for _, v := range []interface{}{"aGk=", "aGk", rt.NewArrayBuffer([]byte("hi"))} {
+		msg, err := tpl.build(map[string]interface{}{"payload": v})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := msg.ProtoReflect()
+		if payload := m.Get(m.Descriptor().Fields().ByName("payload")).Bytes(); string(payload) != "hi" {
+			t.Errorf("unexpected payload %q of %v", payload, v)
+		}
+	}
+	if _, err = tpl.build(map[string]interface{}{"payload": "not base64!"}); err == nil {
+		t.Error("expected an error for an invalid base64 payload")
+	}
+	if _, err = compileTemplate(md, []byte(`{"id": "{{nope(1)}}"}`), protojson.UnmarshalOptions{}); err == nil {
+		t.Error("expected an error for an unknown generator")
+	}
+}
@@ -0,0 +1,590 @@
+package grpc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/shlsky/xk6-grpc/xgrpc_conn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	ruleType         = "type"
+	ruleUnknownField = "unknown_field"
+	ruleRequired     = "required"
+
+	// the numbers of the FieldOptions extensions of buf.validate and protoc-gen-validate
+	bufValidateFieldNumber = 1159
+	pgvRulesFieldNumber    = 1071
+)
+
+// violation is a problem of a request field, the path is relative to the request message.
+type violation struct {
+	Field   string `js:"field"`
+	Rule    string `js:"rule"`
+	Message string `js:"message"`
+}
+
+// newViolation returns the violation of the field at path, the path starts with the message name.
+func newViolation(path, rule, message string) violation {
+	field := ""
+	if i := strings.IndexAny(path, ".["); i >= 0 {
+		field = strings.TrimPrefix(path[i:], ".")
+	}
+	return violation{Field: field, Rule: rule, Message: message}
+}
+
+// invalidRequestError returns the error of a response to a request rejected by the validation.
+func invalidRequestError(violations []violation) map[string]interface{} {
+	list := make([]interface{}, 0, len(violations))
+	for _, v := range violations {
+		list = append(list, map[string]interface{}{"field": v.Field, "rule": v.Rule, "message": v.Message})
+	}
+	return map[string]interface{}{
+		"code":       int32(codes.InvalidArgument),
+		"message":    fmt.Sprintf("invalid request: %d violation(s), first: %s: %s", len(violations), violations[0].Field, violations[0].Message),
+		"violations": list,
+	}
+}
+
+// constraintValidator checks a message against the buf.validate and protoc-gen-validate
+// rules of its fields. The standard rules are supported, the CEL expressions are ignored.
+type constraintValidator struct {
+	types         xgrpc_conn.TypeResolver
+	cache         *constraintCache
+	useProtoNames bool
+	violations    []violation
+}
+
+// constraintCache holds the rules of the fields, nil when a field has none, and their compiled
+// patterns. It belongs to a client, the rules of the extensions are resolved with its types.
+type constraintCache struct {
+	fields   sync.Map // protoreflect.FieldDescriptor to protoreflect.Message
+	patterns sync.Map // string to *regexp.Regexp, nil when invalid
+}
+
+// pattern returns the compiled pattern, nil when it doesn't compile.
+func (c *constraintCache) pattern(p string) *regexp.Regexp {
+	if cached, ok := c.patterns.Load(p); ok {
+		re, _ := cached.(*regexp.Regexp)
+		return re
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		re = nil
+	}
+	c.patterns.Store(p, re)
+	return re
+}
+
+// validateConstraints returns the violations of the rules of the message.
+// The rules are cached in cache, a new one is used when it is nil.
+func validateConstraints(
+	m protoreflect.Message, types xgrpc_conn.TypeResolver, cache *constraintCache, useProtoNames bool,
+) []violation {
+	if types == nil {
+		return nil
+	}
+	if cache == nil {
+		cache = &constraintCache{}
+	}
+	v := &constraintValidator{types: types, cache: cache, useProtoNames: useProtoNames}
+	v.message(m, string(m.Descriptor().Name()))
+	return v.violations
+}
+
+func (v *constraintValidator) add(path, rule, format string, args ...interface{}) {
+	v.violations = append(v.violations, newViolation(path, rule, fmt.Sprintf(format, args...)))
+}
+
+func (v *constraintValidator) message(m protoreflect.Message, path string) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := fd.JSONName()
+		if v.useProtoNames {
+			name = string(fd.Name())
+		}
+		v.field(m, fd, path+"."+name)
+	}
+}
+
+func (v *constraintValidator) field(m protoreflect.Message, fd protoreflect.FieldDescriptor, path string) {
+	rules := v.rules(fd)
+	populated := m.Has(fd)
+	if rules != nil {
+		if ignored(rules, populated) {
+			return
+		}
+		if required(rules) && !populated {
+			v.add(path, ruleRequired, "value is required")
+			return
+		}
+		if fd.HasPresence() && !populated {
+			return
+		}
+		v.typeRules(rules, fd, m.Get(fd), path)
+	}
+	if fd.Message() == nil || !populated || skipped(rules) {
+		return
+	}
+
+	switch {
+	case fd.IsList():
+		list := m.Get(fd).List()
+		for i := 0; i < list.Len(); i++ {
+			v.message(list.Get(i).Message(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case fd.IsMap():
+		if fd.MapValue().Message() == nil {
+			return
+		}
+		m.Get(fd).Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			v.message(mv.Message(), fmt.Sprintf("%s[%q]", path, k.String()))
+			return true
+		})
+	default:
+		if !isWellKnownType(fd.Message()) {
+			v.message(m.Get(fd).Message(), path)
+		}
+	}
+}
+
+// rules returns the rules of the field, set with the buf.validate.field or the validate.rules option.
+// The options are unknown fields of the FieldOptions when their extensions were not loaded
+// with the descriptors, they are resolved with the client's types. The rules of an unresolved
+// extension aren't cached, its type can be loaded later.
+func (v *constraintValidator) rules(fd protoreflect.FieldDescriptor) protoreflect.Message {
+	if cached, ok := v.cache.fields.Load(fd); ok {
+		rules, _ := cached.(protoreflect.Message)
+		return rules
+	}
+
+	var rules protoreflect.Message
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	if ok {
+		opts.ProtoReflect().Range(func(xd protoreflect.FieldDescriptor, val protoreflect.Value) bool {
+			if xd.IsExtension() && (xd.Number() == bufValidateFieldNumber || xd.Number() == pgvRulesFieldNumber) {
+				rules = val.Message()
+				return false
+			}
+			return true
+		})
+		if rules == nil && len(opts.ProtoReflect().GetUnknown()) > 0 {
+			if rules = v.resolveRules(opts); rules == nil {
+				return nil
+			}
+		}
+	}
+	v.cache.fields.Store(fd, rules)
+	return rules
+}
+
+func (v *constraintValidator) resolveRules(opts *descriptorpb.FieldOptions) protoreflect.Message {
+	b, err := proto.Marshal(opts)
+	if err != nil {
+		return nil
+	}
+	resolved := &descriptorpb.FieldOptions{}
+	if err = (proto.UnmarshalOptions{Resolver: v.types}).Unmarshal(b, resolved); err != nil {
+		return nil
+	}
+	for _, number := range []protoreflect.FieldNumber{bufValidateFieldNumber, pgvRulesFieldNumber} {
+		xt, err := v.types.FindExtensionByNumber("google.protobuf.FieldOptions", number)
+		if err != nil || !proto.HasExtension(resolved, xt) {
+			continue
+		}
+		return resolved.ProtoReflect().Get(xt.TypeDescriptor()).Message()
+	}
+	return nil
+}
+
+// ignored reports whether the rules are skipped, as set by the ignore rule of buf.validate.
+func ignored(rules protoreflect.Message, populated bool) bool {
+	fd := rules.Descriptor().Fields().ByName("ignore")
+	if fd == nil || fd.Enum() == nil || !rules.Has(fd) {
+		return false
+	}
+	ev := fd.Enum().Values().ByNumber(rules.Get(fd).Enum())
+	if ev == nil {
+		return false
+	}
+	name := string(ev.Name())
+	switch {
+	case strings.Contains(name, "ALWAYS"):
+		return true
+	case strings.HasSuffix(name, "UNSPECIFIED"):
+		return false
+	default:
+		// IGNORE_IF_UNPOPULATED, IGNORE_IF_DEFAULT_VALUE and their former names
+		return !populated
+	}
+}
+
+func required(rules protoreflect.Message) bool {
+	if r, ok := ruleValue(rules, "required"); ok && r.Bool() {
+		return true
+	}
+	// protoc-gen-validate
+	if mr, ok := ruleValue(rules, "message"); ok {
+		if r, ok := ruleValue(mr.Message(), "required"); ok && r.Bool() {
+			return true
+		}
+	}
+	return false
+}
+
+func skipped(rules protoreflect.Message) bool {
+	if rules == nil {
+		return false
+	}
+	if mr, ok := ruleValue(rules, "message"); ok {
+		if r, ok := ruleValue(mr.Message(), "skip"); ok && r.Bool() {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleValue returns the value of the rule, when set.
+func ruleValue(rules protoreflect.Message, name protoreflect.Name) (protoreflect.Value, bool) {
+	fd := rules.Descriptor().Fields().ByName(name)
+	if fd == nil || !rules.Has(fd) {
+		return protoreflect.Value{}, false
+	}
+	return rules.Get(fd), true
+}
+
+// typeRules applies the rules of the field's type, e.g. the string or repeated ones.
+func (v *constraintValidator) typeRules(rules protoreflect.Message, fd protoreflect.FieldDescriptor, val protoreflect.Value, path string) {
+	switch {
+	case fd.IsList():
+		rr, ok := ruleValue(rules, "repeated")
+		if !ok {
+			return
+		}
+		v.repeatedRules(rr.Message(), fd, val.List(), path)
+	case fd.IsMap():
+		mr, ok := ruleValue(rules, "map")
+		if !ok {
+			return
+		}
+		v.mapRules(mr.Message(), fd, val.Map(), path)
+	default:
+		v.scalarRules(rules, fd, val, path)
+	}
+}
+
+func (v *constraintValidator) repeatedRules(rules protoreflect.Message, fd protoreflect.FieldDescriptor, list protoreflect.List, path string) {
+	if n, ok := ruleValue(rules, "min_items"); ok && uint64(list.Len()) < n.Uint() {
+		v.add(path, "repeated.min_items", "must contain at least %d item(s)", n.Uint())
+	}
+	if n, ok := ruleValue(rules, "max_items"); ok && uint64(list.Len()) > n.Uint() {
+		v.add(path, "repeated.max_items", "must contain no more than %d item(s)", n.Uint())
+	}
+	if u, ok := ruleValue(rules, "unique"); ok && u.Bool() && fd.Message() == nil {
+		seen := make(map[interface{}]bool, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			key := list.Get(i).Interface()
+			if b, ok := key.([]byte); ok {
+				key = string(b)
+			}
+			if seen[key] {
+				v.add(path, "repeated.unique", "repeated value must contain unique items")
+				break
+			}
+			seen[key] = true
+		}
+	}
+	if items, ok := ruleValue(rules, "items"); ok {
+		for i := 0; i < list.Len(); i++ {
+			v.scalarRules(items.Message(), fd, list.Get(i), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func (v *constraintValidator) mapRules(rules protoreflect.Message, fd protoreflect.FieldDescriptor, mp protoreflect.Map, path string) {
+	if n, ok := ruleValue(rules, "min_pairs"); ok && uint64(mp.Len()) < n.Uint() {
+		v.add(path, "map.min_pairs", "map must be at least %d entries", n.Uint())
+	}
+	if n, ok := ruleValue(rules, "max_pairs"); ok && uint64(mp.Len()) > n.Uint() {
+		v.add(path, "map.max_pairs", "map must be at most %d entries", n.Uint())
+	}
+	keys, hasKeys := ruleValue(rules, "keys")
+	values, hasValues := ruleValue(rules, "values")
+	if !hasKeys && !hasValues {
+		return
+	}
+	mp.Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+		entryPath := fmt.Sprintf("%s[%q]", path, k.String())
+		if hasKeys {
+			v.scalarRules(keys.Message(), fd.MapKey(), k.Value(), entryPath)
+		}
+		if hasValues {
+			v.scalarRules(values.Message(), fd.MapValue(), mv, entryPath)
+		}
+		return true
+	})
+}
+
+// scalarRules applies the rules of a single value, they are in the field of the rules
+// named after the value's kind, e.g. string or int32.
+func (v *constraintValidator) scalarRules(rules protoreflect.Message, fd protoreflect.FieldDescriptor, val protoreflect.Value, path string) {
+	kind := fd.Kind().String()
+	if fd.Kind() == protoreflect.MessageKind {
+		return
+	}
+	tr, ok := ruleValue(rules, protoreflect.Name(kind))
+	if !ok {
+		return
+	}
+	r := tr.Message()
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		v.stringRules(r, val.String(), path)
+	case protoreflect.BytesKind:
+		v.bytesRules(r, val.Bytes(), path)
+	case protoreflect.EnumKind:
+		v.enumRules(r, fd, val.Enum(), path)
+	case protoreflect.BoolKind:
+		if c, ok := ruleValue(r, "const"); ok && c.Bool() != val.Bool() {
+			v.add(path, "bool.const", "value must equal %v", c.Bool())
+		}
+	default:
+		v.numberRules(r, fd.Kind(), val, path)
+	}
+}
+
+//nolint:cyclop,funlen
+func (v *constraintValidator) stringRules(r protoreflect.Message, s string, path string) {
+	length := uint64(utf8.RuneCountInString(s))
+	if c, ok := ruleValue(r, "const"); ok && c.String() != s {
+		v.add(path, "string.const", "value must equal %q", c.String())
+	}
+	if n, ok := ruleValue(r, "len"); ok && length != n.Uint() {
+		v.add(path, "string.len", "value length must be %d characters", n.Uint())
+	}
+	if n, ok := ruleValue(r, "min_len"); ok && length < n.Uint() {
+		v.add(path, "string.min_len", "value length must be at least %d characters", n.Uint())
+	}
+	if n, ok := ruleValue(r, "max_len"); ok && length > n.Uint() {
+		v.add(path, "string.max_len", "value length must be at most %d characters", n.Uint())
+	}
+	if n, ok := ruleValue(r, "min_bytes"); ok && uint64(len(s)) < n.Uint() {
+		v.add(path, "string.min_bytes", "value length must be at least %d bytes", n.Uint())
+	}
+	if n, ok := ruleValue(r, "max_bytes"); ok && uint64(len(s)) > n.Uint() {
+		v.add(path, "string.max_bytes", "value length must be at most %d bytes", n.Uint())
+	}
+	if p, ok := ruleValue(r, "pattern"); ok {
+		if re := v.cache.pattern(p.String()); re != nil && !re.MatchString(s) {
+			v.add(path, "string.pattern", "value does not match regex pattern %q", p.String())
+		}
+	}
+	if p, ok := ruleValue(r, "prefix"); ok && !strings.HasPrefix(s, p.String()) {
+		v.add(path, "string.prefix", "value does not have prefix %q", p.String())
+	}
+	if p, ok := ruleValue(r, "suffix"); ok && !strings.HasSuffix(s, p.String()) {
+		v.add(path, "string.suffix", "value does not have suffix %q", p.String())
+	}
+	if p, ok := ruleValue(r, "contains"); ok && !strings.Contains(s, p.String()) {
+		v.add(path, "string.contains", "value does not contain substring %q", p.String())
+	}
+	if p, ok := ruleValue(r, "not_contains"); ok && strings.Contains(s, p.String()) {
+		v.add(path, "string.not_contains", "value contains substring %q", p.String())
+	}
+	if in, ok := ruleValue(r, "in"); ok && in.List().Len() > 0 && !listContains(in.List(), protoreflect.ValueOfString(s)) {
+		v.add(path, "string.in", "value must be in list %v", in.List())
+	}
+	if in, ok := ruleValue(r, "not_in"); ok && listContains(in.List(), protoreflect.ValueOfString(s)) {
+		v.add(path, "string.not_in", "value must not be in list %v", in.List())
+	}
+
+	for _, f := range stringFormats {
+		if enabled, ok := ruleValue(r, f.name); ok && enabled.Bool() && !f.valid(s) {
+			v.add(path, "string."+string(f.name), "value must be a valid %s", f.name)
+		}
+	}
+}
+
+// stringFormats are the well-known formats of the string rules, in the order they are checked.
+var stringFormats = []struct {
+	name  protoreflect.Name
+	valid func(string) bool
+}{
+	{"email", func(s string) bool {
+		a, err := mail.ParseAddress(s)
+		return err == nil && a.Address == s
+	}},
+	{"hostname", isHostname},
+	{"ip", func(s string) bool { return net.ParseIP(s) != nil }},
+	{"ipv4", func(s string) bool { ip := net.ParseIP(s); return ip != nil && ip.To4() != nil }},
+	{"ipv6", func(s string) bool { ip := net.ParseIP(s); return ip != nil && ip.To4() == nil }},
+	{"uri", func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	}},
+	{"uuid", func(s string) bool {
+		_, err := uuid.Parse(s)
+		return err == nil && len(s) == 36
+	}},
+}
+
+func isHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (v *constraintValidator) bytesRules(r protoreflect.Message, b []byte, path string) {
+	length := uint64(len(b))
+	if c, ok := ruleValue(r, "const"); ok && !bytes.Equal(c.Bytes(), b) {
+		v.add(path, "bytes.const", "value must equal the constant")
+	}
+	if n, ok := ruleValue(r, "len"); ok && length != n.Uint() {
+		v.add(path, "bytes.len", "value length must be %d bytes", n.Uint())
+	}
+	if n, ok := ruleValue(r, "min_len"); ok && length < n.Uint() {
+		v.add(path, "bytes.min_len", "value length must be at least %d bytes", n.Uint())
+	}
+	if n, ok := ruleValue(r, "max_len"); ok && length > n.Uint() {
+		v.add(path, "bytes.max_len", "value length must be at most %d bytes", n.Uint())
+	}
+	if p, ok := ruleValue(r, "prefix"); ok && !bytes.HasPrefix(b, p.Bytes()) {
+		v.add(path, "bytes.prefix", "value does not have the prefix")
+	}
+	if p, ok := ruleValue(r, "suffix"); ok && !bytes.HasSuffix(b, p.Bytes()) {
+		v.add(path, "bytes.suffix", "value does not have the suffix")
+	}
+	if p, ok := ruleValue(r, "contains"); ok && !bytes.Contains(b, p.Bytes()) {
+		v.add(path, "bytes.contains", "value does not contain the bytes")
+	}
+}
+
+func (v *constraintValidator) enumRules(r protoreflect.Message, fd protoreflect.FieldDescriptor, n protoreflect.EnumNumber, path string) {
+	if c, ok := ruleValue(r, "const"); ok && c.Int() != int64(n) {
+		v.add(path, "enum.const", "value must equal %d", c.Int())
+	}
+	if d, ok := ruleValue(r, "defined_only"); ok && d.Bool() && fd.Enum().Values().ByNumber(n) == nil {
+		v.add(path, "enum.defined_only", "value must be one of the defined enum values")
+	}
+	if in, ok := ruleValue(r, "in"); ok && in.List().Len() > 0 && !listContains(in.List(), protoreflect.ValueOfInt32(int32(n))) {
+		v.add(path, "enum.in", "value must be in list %v", in.List())
+	}
+	if in, ok := ruleValue(r, "not_in"); ok && listContains(in.List(), protoreflect.ValueOfInt32(int32(n))) {
+		v.add(path, "enum.not_in", "value must not be in list %v", in.List())
+	}
+}
+
+// numberRules applies the rules of the numeric kinds, their bounds have the kind of the value.
+// As in buf.validate, a lower bound greater than the upper one requires a value outside the range.
+func (v *constraintValidator) numberRules(r protoreflect.Message, kind protoreflect.Kind, val protoreflect.Value, path string) {
+	name := kind.String()
+	cmp := func(bound protoreflect.Value) int { return compareNumbers(kind, val, bound) }
+
+	if c, ok := ruleValue(r, "const"); ok && cmp(c) != 0 {
+		v.add(path, name+".const", "value must equal %v", c.Interface())
+	}
+	if in, ok := ruleValue(r, "in"); ok && in.List().Len() > 0 && !listContainsNumber(kind, in.List(), val) {
+		v.add(path, name+".in", "value must be in list %v", in.List())
+	}
+	if in, ok := ruleValue(r, "not_in"); ok && listContainsNumber(kind, in.List(), val) {
+		v.add(path, name+".not_in", "value must not be in list %v", in.List())
+	}
+
+	type bound struct {
+		rule  string
+		value protoreflect.Value
+		ok    func(int) bool
+		text  string
+	}
+	var lower, upper *bound
+	if b, ok := ruleValue(r, "gt"); ok {
+		lower = &bound{"gt", b, func(c int) bool { return c > 0 }, "greater than"}
+	} else if b, ok := ruleValue(r, "gte"); ok {
+		lower = &bound{"gte", b, func(c int) bool { return c >= 0 }, "greater than or equal to"}
+	}
+	if b, ok := ruleValue(r, "lt"); ok {
+		upper = &bound{"lt", b, func(c int) bool { return c < 0 }, "less than"}
+	} else if b, ok := ruleValue(r, "lte"); ok {
+		upper = &bound{"lte", b, func(c int) bool { return c <= 0 }, "less than or equal to"}
+	}
+
+	switch {
+	case lower != nil && upper != nil && compareNumbers(kind, lower.value, upper.value) > 0:
+		if !lower.ok(cmp(lower.value)) && !upper.ok(cmp(upper.value)) {
+			v.add(path, name+"."+lower.rule+"_"+upper.rule+"_exclusive", "value must be %s %v or %s %v",
+				lower.text, lower.value.Interface(), upper.text, upper.value.Interface())
+		}
+	default:
+		if lower != nil && !lower.ok(cmp(lower.value)) {
+			v.add(path, name+"."+lower.rule, "value must be %s %v", lower.text, lower.value.Interface())
+		}
+		if upper != nil && !upper.ok(cmp(upper.value)) {
+			v.add(path, name+"."+upper.rule, "value must be %s %v", upper.text, upper.value.Interface())
+		}
+	}
+}
+
+func compareNumbers(kind protoreflect.Kind, a, b protoreflect.Value) int {
+	switch kind {
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return compare(a.Float(), b.Float())
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+		return compare(a.Uint(), b.Uint())
+	default:
+		return compare(a.Int(), b.Int())
+	}
+}
+
+func compare[T int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func listContainsNumber(kind protoreflect.Kind, list protoreflect.List, val protoreflect.Value) bool {
+	for i := 0; i < list.Len(); i++ {
+		if compareNumbers(kind, val, list.Get(i)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func listContains(list protoreflect.List, val protoreflect.Value) bool {
+	for i := 0; i < list.Len(); i++ {
+		if list.Get(i).Equal(val) {
+			return true
+		}
+	}
+	return false
+}
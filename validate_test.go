@@ -0,0 +1,289 @@
+package grpc
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/grafana/sobek"
+	"github.com/shlsky/xk6-grpc/xgrpc_conn"
+	"go.k6.io/k6/js/modulestest"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// a subset of buf/validate/validate.proto, enough for the rules under test
+const validateTestRulesProto = `syntax = "proto2";
+package buf.validate;
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.FieldOptions { optional FieldConstraints field = 1159; }
+
+message FieldConstraints {
+  optional bool required = 25;
+  oneof type {
+    Int32Rules int32 = 3;
+    StringRules string = 14;
+    EnumRules enum = 16;
+    RepeatedRules repeated = 18;
+  }
+}
+message Int32Rules {
+  optional int32 lt = 2;
+  optional int32 lte = 3;
+  optional int32 gt = 4;
+  optional int32 gte = 5;
+}
+message StringRules {
+  optional uint64 min_len = 2;
+  optional string pattern = 6;
+  optional bool email = 12;
+  optional bool hostname = 13;
+  optional bool ipv4 = 15;
+}
+message EnumRules { optional bool defined_only = 2; }
+message RepeatedRules {
+  optional uint64 min_items = 1;
+  optional uint64 max_items = 2;
+  optional bool unique = 3;
+  optional FieldConstraints items = 4;
+}
+`
+
+const validateTestProto = `syntax = "proto3";
+package val;
+import "buf/validate/validate.proto";
+
+enum Status {
+  STATUS_UNSPECIFIED = 0;
+  STATUS_OK = 1;
+}
+
+message Item {
+  string sku = 1 [(buf.validate.field).string = {min_len: 3, pattern: "^[A-Z]+$"}];
+}
+
+message Host {
+  string addr = 1 [(buf.validate.field).string = {ipv4: true, hostname: true, email: true}];
+}
+
+message Order {
+  string email = 1 [(buf.validate.field).string.email = true];
+  int32 quantity = 2 [(buf.validate.field).int32 = {gt: 0, lte: 100}];
+  repeated string tags = 3 [(buf.validate.field).repeated = {max_items: 2, unique: true}];
+  Item item = 4 [(buf.validate.field).required = true];
+  repeated Item items = 5;
+  Status status = 6 [(buf.validate.field).enum.defined_only = true];
+  int32 small = 7;
+  Status other = 8;
+  optional int32 outside = 9 [(buf.validate.field).int32 = {lt: 10, gt: 20}];
+}
+
+service Orders {
+  rpc Place(Order) returns (Order);
+}
+`
+
+func TestValidateRequest(t *testing.T) {
+	t.Parallel()
+
+	fdset, err := compileProtos(&protocompile.SourceResolver{
+		Accessor: protocompile.SourceAccessorFromMap(map[string]string{
+			"buf/validate/validate.proto": validateTestRulesProto,
+			"val.proto":                   validateTestProto,
+		}),
+	}, "val.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := xgrpc_conn.NewRegistry()
+	if _, err = registry.Register(fdset); err != nil {
+		t.Fatal(err)
+	}
+	desc, err := registry.FindDescriptorByName("val.Order")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rt := sobek.New()
+	validate := func(t *testing.T, js string) []string {
+		t.Helper()
+		v, err := rt.RunString(js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		violations := []violation{}
+		conv := jsConverter{rt: rt, json: xgrpc_conn.DefaultJSONOptions(), types: registry, violations: &violations}
+		msg, err := conv.messageFromJS(desc.(protoreflect.MessageDescriptor), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		violations = append(violations, validateConstraints(msg, registry, nil, false)...)
+		got := make([]string, 0, len(violations))
+		for _, v := range violations {
+			got = append(got, v.Field+" "+v.Rule)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	got := validate(t, `({
+		email: "nope",
+		quantity: 0,
+		tags: ["a", "a", "b"],
+		items: [{sku: "AB"}, {sku: "ABC"}],
+		status: 5,
+		small: 3000000000,
+		other: "STATUS_MISSING",
+		outside: 15,
+		unknownKey: 1,
+	})`)
+	expected := []string{
+		"email string.email",
+		"item required",
+		"items[0].sku string.min_len",
+		"other type",
+		"outside int32.gt_lt_exclusive",
+		"quantity int32.gt",
+		"small type",
+		"status enum.defined_only",
+		"tags repeated.max_items",
+		"tags repeated.unique",
+		"unknownKey unknown_field",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected violations:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	valid := validate(t, `({email: "a@example.com", quantity: 100, tags: ["a"], item: {sku: "ABC"}, status: "STATUS_OK", outside: 5})`)
+	if len(valid) != 0 {
+		t.Fatalf("unexpected violations of a valid request: %v", valid)
+	}
+
+	// the formats broken by a value are reported in the same order on every run
+	host, err := registry.FindDescriptorByName("val.Host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostMsg := dynamicpb.NewMessage(host.(protoreflect.MessageDescriptor))
+	hostMsg.Set(host.(protoreflect.MessageDescriptor).Fields().ByName("addr"), protoreflect.ValueOfString("not a host"))
+	for i := 0; i < 10; i++ {
+		var rules []string
+		for _, v := range validateConstraints(hostMsg, registry, nil, false) {
+			rules = append(rules, v.Rule)
+		}
+		if expected := []string{"string.email", "string.hostname", "string.ipv4"}; !reflect.DeepEqual(rules, expected) {
+			t.Fatalf("expected the violations %v, got %v", expected, rules)
+		}
+	}
+
+	// the rules aren't cached while their extension can't be resolved, the options of the
+	// descriptors built without the extension hold them as unknown fields
+	b, err := proto.Marshal(fdset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unresolved := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(b, unresolved); err != nil {
+		t.Fatal(err)
+	}
+	unresolved.File = append(unresolved.File, protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto))
+	files, err := protodesc.NewFiles(unresolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := files.FindDescriptorByName("val.Item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &constraintCache{}
+	msg := dynamicpb.NewMessage(item.(protoreflect.MessageDescriptor))
+	msg.Set(item.(protoreflect.MessageDescriptor).Fields().ByName("sku"), protoreflect.ValueOfString("ab"))
+	if v := validateConstraints(msg, xgrpc_conn.NewRegistry(), cache, false); len(v) != 0 {
+		t.Fatalf("the rules can't be resolved without their extension, got %v", v)
+	}
+	if v := validateConstraints(msg, registry, cache, false); len(v) != 2 {
+		t.Fatalf("expected the min_len and pattern violations once the extension is resolved, got %v", v)
+	}
+}
+
+func TestNewViolation(t *testing.T) {
+	t.Parallel()
+
+	for path, field := range map[string]string{
+		"Order":               "",
+		"Order.items[0].sku":  "items[0].sku",
+		`Order.labels["a.b"]`: `labels["a.b"]`,
+	} {
+		if v := newViolation(path, ruleType, "msg"); v.Field != field {
+			t.Errorf("unexpected field %q of path %q, expected %q", v.Field, path, field)
+		}
+	}
+
+	err := invalidRequestError([]violation{{Field: "email", Rule: "string.email", Message: "value must be a valid email"}})
+	if !strings.Contains(err["message"].(string), "email") || len(err["violations"].([]interface{})) != 1 {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestValidateEncodedRequest(t *testing.T) {
+	t.Parallel()
+
+	fdset, err := compileProtos(&protocompile.SourceResolver{
+		Accessor: protocompile.SourceAccessorFromMap(map[string]string{
+			"buf/validate/validate.proto": validateTestRulesProto,
+			"val.proto":                   validateTestProto,
+		}),
+	}, "val.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := sobek.New()
+	c := &Client{vu: &modulestest.VU{RuntimeField: rt}}
+	if _, err = c.base().Registry().Register(fdset); err != nil {
+		t.Fatal(err)
+	}
+	md, err := c.base().Registry().FindDescriptorByName("val.Orders.Place")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := md.(protoreflect.MethodDescriptor).Input()
+	order := dynamicpb.NewMessage(input)
+	order.Set(input.Fields().ByName("email"), protoreflect.ValueOfString("a@example.com"))
+	order.Set(input.Fields().ByName("quantity"), protoreflect.ValueOfInt32(200))
+	b, err := proto.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the encoded requests are decoded to be validated, and still sent as they were encoded
+	p := &invokeParams{Validate: true}
+	for _, req := range []sobek.Value{rt.ToValue(rt.NewArrayBuffer(b)), rt.ToValue(`email: "a@example.com" quantity: 200`)} {
+		reqmsg := &xgrpc_conn.Request{MethodDescriptor: md.(protoreflect.MethodDescriptor)}
+		violations, err := c.encodeRequest(req, p, reqmsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rules []string
+		for _, v := range violations {
+			rules = append(rules, v.Field+" "+v.Rule)
+		}
+		sort.Strings(rules)
+		if expected := []string{"item required", "quantity int32.lte"}; !reflect.DeepEqual(rules, expected) {
+			t.Errorf("expected the violations %v, got %v", expected, rules)
+		}
+		if reqmsg.ProtoMessage != nil || len(reqmsg.Message) == 0 {
+			t.Errorf("the encoded request should be sent as is")
+		}
+	}
+
+	reqmsg := &xgrpc_conn.Request{MethodDescriptor: md.(protoreflect.MethodDescriptor)}
+	if _, err = c.encodeRequest(rt.ToValue("quantity: nope"), p, reqmsg); err == nil {
+		t.Fatal("expected an error for a request that can't be decoded")
+	}
+}
@@ -0,0 +1,221 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Client invokes the methods of its loaded descriptors with dynamic messages.
+// It doesn't depend on a k6 VU, so it can be used from Go tests and other extensions.
+type Client struct {
+	conn     *Conn
+	registry *Registry
+	methods  map[string]protoreflect.MethodDescriptor
+}
+
+// DialClient returns a client connected to addr, see Connect.
+func DialClient(ctx context.Context, addr string, o Options) (*Client, error) {
+	conn, err := Connect(ctx, addr, o)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithConn(conn), nil
+}
+
+// NewClientWithConn returns a client using the connection, it has no descriptors loaded.
+// The connection can be nil, to load the descriptors before connecting, and set with SetConn.
+func NewClientWithConn(conn *Conn) *Client {
+	return &Client{
+		conn:     conn,
+		registry: NewRegistry(),
+		methods:  make(map[string]protoreflect.MethodDescriptor),
+	}
+}
+
+// Conn returns the connection of the client, nil when it has none.
+func (c *Client) Conn() *Conn {
+	return c.conn
+}
+
+// SetConn replaces the connection of the client, it doesn't close the previous one.
+func (c *Client) SetConn(conn *Conn) {
+	c.conn = conn
+}
+
+// WithAuthority returns the client with the same descriptors using the connection
+// of the authority, see Conn.WithAuthority.
+func (c *Client) WithAuthority(authority string) (*Client, error) {
+	if c.conn == nil {
+		return nil, errNoConn
+	}
+	conn, err := c.conn.WithAuthority(authority)
+	if err != nil || conn == c.conn {
+		return c, err
+	}
+	return &Client{conn: conn, registry: c.registry, methods: c.methods}, nil
+}
+
+// Registry returns the registry of the loaded descriptors.
+func (c *Client) Registry() *Registry {
+	return c.registry
+}
+
+// LoadFileDescriptorSet loads the files of the set and returns the full names
+// of their methods, as /package.Service/Method.
+func (c *Client) LoadFileDescriptorSet(fdset *descriptorpb.FileDescriptorSet) ([]string, error) {
+	files, err := c.registry.Register(fdset)
+	if err != nil {
+		return nil, err
+	}
+	return c.LoadFiles(files)
+}
+
+// LoadFiles loads already built files, e.g. shared with other clients, and returns the full names
+// of their methods. The files must be ordered with their imports first, a file already loaded
+// is skipped when it is the same instance and an error otherwise.
+func (c *Client) LoadFiles(files []protoreflect.FileDescriptor) ([]string, error) {
+	for _, fd := range files {
+		if loaded, err := c.registry.Files().FindFileByPath(fd.Path()); err == nil && loaded != fd {
+			return nil, fmt.Errorf("%w: %s", ErrFileLoaded, fd.Path())
+		}
+	}
+	if err := c.registry.RegisterFiles(files); err != nil {
+		return nil, err
+	}
+	mds := MethodDescriptors(files)
+	names := make([]string, 0, len(mds))
+	for name, md := range mds {
+		c.methods[name] = md
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// LoadProtoset loads a serialized FileDescriptorSet, as generated by protoc --descriptor_set_out.
+func (c *Client) LoadProtoset(b []byte) ([]string, error) {
+	fdset := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, fdset); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal the protoset: %w", err)
+	}
+	return c.LoadFileDescriptorSet(fdset)
+}
+
+// ErrFileLoaded is returned by LoadFiles when another instance of a file is already loaded.
+var ErrFileLoaded = errors.New("another descriptor of the file is already loaded")
+
+var errNoConn = errors.New("the client has no connection")
+
+// Reflect loads the descriptors of the services using the server reflection,
+// all the services exposed by the server when none is given.
+func (c *Client) Reflect(ctx context.Context, services ...string) ([]string, error) {
+	if c.conn == nil {
+		return nil, errNoConn
+	}
+	fdset, err := c.conn.Reflect(ctx, services...)
+	if err != nil {
+		return nil, err
+	}
+	return c.LoadFileDescriptorSet(fdset)
+}
+
+// Method returns the descriptor of a loaded method, the leading slash is optional.
+func (c *Client) Method(method string) (protoreflect.MethodDescriptor, error) {
+	if !strings.HasPrefix(method, "/") {
+		method = "/" + method
+	}
+	md, ok := c.methods[method]
+	if !ok {
+		return nil, fmt.Errorf("method %q not found in file descriptors", method)
+	}
+	return md, nil
+}
+
+// NewRequest returns an empty input message of the method.
+func (c *Client) NewRequest(method string) (*dynamicpb.Message, error) {
+	md, err := c.Method(method)
+	if err != nil {
+		return nil, err
+	}
+	return dynamicpb.NewMessage(md.Input()), nil
+}
+
+// Invoke calls a unary method with the request message, the response message is
+// Response.ProtoMessage. A gRPC error status is in the response, not returned.
+func (c *Client) Invoke(
+	ctx context.Context,
+	method string,
+	req proto.Message,
+	md metadata.MD,
+	opts ...grpc.CallOption,
+) (*Response, error) {
+	return c.InvokeRequest(ctx, method, md, Request{ProtoMessage: req, ProtoResponse: true}, opts...)
+}
+
+// InvokeRequest calls a unary method with the request, in any of its formats. The method descriptor
+// and the types of the request are the client's ones when they aren't set.
+// A gRPC error status is in the response, not returned.
+func (c *Client) InvokeRequest(
+	ctx context.Context,
+	method string,
+	md metadata.MD,
+	req Request,
+	opts ...grpc.CallOption,
+) (*Response, error) {
+	if c.conn == nil {
+		return nil, errNoConn
+	}
+	if req.MethodDescriptor == nil {
+		desc, err := c.Method(method)
+		if err != nil {
+			return nil, err
+		}
+		req.MethodDescriptor = desc
+	}
+	if req.Types == nil {
+		req.Types = c.registry
+	}
+	if md == nil {
+		md = metadata.MD{}
+	}
+	desc := req.MethodDescriptor
+	return c.conn.InvokeRequest(ctx, fmt.Sprintf("/%s/%s", desc.Parent().FullName(), desc.Name()), md, req, opts...)
+}
+
+// Close closes the connection, the descriptors are kept.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// MethodDescriptors returns the methods of the services declared in the files,
+// by their full name as /package.Service/Method.
+func MethodDescriptors(files []protoreflect.FileDescriptor) map[string]protoreflect.MethodDescriptor {
+	mds := make(map[string]protoreflect.MethodDescriptor)
+	for _, fd := range files {
+		sds := fd.Services()
+		for i := 0; i < sds.Len(); i++ {
+			sd := sds.Get(i)
+			methods := sd.Methods()
+			for j := 0; j < methods.Len(); j++ {
+				md := methods.Get(j)
+				mds[fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())] = md
+			}
+		}
+	}
+	return mds
+}
@@ -0,0 +1,135 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.k6.io/k6/lib"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	grpcstats "google.golang.org/grpc/stats"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gopkg.in/guregu/null.v3"
+)
+
+type recordingSink struct {
+	mu   sync.Mutex
+	ends int
+}
+
+func (s *recordingSink) HandleRPC(_ context.Context, stat grpcstats.RPCStats) {
+	if _, ok := stat.(*grpcstats.End); ok {
+		s.mu.Lock()
+		s.ends++
+		s.mu.Unlock()
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	reflection.Register(server)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sink := &recordingSink{}
+	client, err := DialClient(ctx, "bufnet", Options{
+		Dialer: func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) },
+		Sink:   sink,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	methods, err := client.Reflect(ctx, "grpc.health.v1.Health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 2 || methods[0] != "/grpc.health.v1.Health/Check" {
+		t.Fatalf("unexpected methods %v", methods)
+	}
+
+	req, err := client.NewRequest("grpc.health.v1.Health/Check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Invoke(ctx, "grpc.health.v1.Health/Check", req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != codes.OK || resp.Duration == nil {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	msg := resp.ProtoMessage.ProtoReflect()
+	if got := msg.Get(msg.Descriptor().Fields().ByName("status")).Enum(); got != protoreflect.EnumNumber(healthpb.HealthCheckResponse_SERVING) {
+		t.Fatalf("unexpected status %v", got)
+	}
+
+	sink.mu.Lock()
+	ends := sink.ends
+	sink.mu.Unlock()
+	if ends == 0 {
+		t.Fatal("the sink should receive the stats of the RPCs")
+	}
+	if _, err = client.Invoke(ctx, "unknown.Service/Method", req, nil); err == nil {
+		t.Fatal("expected an error for an unknown method")
+	}
+
+	resp, err = client.InvokeRequest(ctx, "/grpc.health.v1.Health/Check", nil, Request{
+		Message: []byte(`{"service": ""}`),
+		Format:  MessageFormatJSON,
+	})
+	if err != nil || resp.Status != codes.OK {
+		t.Fatalf("unexpected response %+v %v", resp, err)
+	}
+
+	// the deprecated Invoke of the connection discards the response with DiscardResponseBodies
+	checkMD := healthpb.File_grpc_health_v1_health_proto.Services().ByName("Health").Methods().ByName("Check")
+	for _, discard := range []bool{false, true} {
+		resp, err = client.Conn().Invoke(ctx, lib.Options{DiscardResponseBodies: null.BoolFrom(discard)},
+			"/grpc.health.v1.Health/Check", nil, Request{MethodDescriptor: checkMD, Message: []byte(`{}`)})
+		if err != nil || resp.Status != codes.OK || (resp.Message == nil) != discard {
+			t.Fatalf("unexpected response %+v %v of discard %t", resp, err, discard)
+		}
+	}
+
+	// another instance of a loaded file is rejected, the same one is skipped
+	md, err := client.Method("/grpc.health.v1.Health/Check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.LoadFiles([]protoreflect.FileDescriptor{healthpb.File_grpc_health_v1_health_proto}); !errors.Is(err, ErrFileLoaded) {
+		t.Fatalf("expected ErrFileLoaded, got %v", err)
+	}
+	if _, err = client.LoadFiles([]protoreflect.FileDescriptor{md.ParentFile()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the descriptors are loaded before connecting
+	lazy := NewClientWithConn(nil)
+	if _, err = lazy.LoadFiles([]protoreflect.FileDescriptor{healthpb.File_grpc_health_v1_health_proto}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lazy.Invoke(ctx, "grpc.health.v1.Health/Check", req, nil); err == nil {
+		t.Fatal("expected an error without a connection")
+	}
+	lazy.SetConn(client.Conn())
+	if resp, err = lazy.Invoke(ctx, "grpc.health.v1.Health/Check", &healthpb.HealthCheckRequest{}, nil); err != nil ||
+		resp.Status != codes.OK {
+		t.Fatalf("unexpected response %+v %v", resp, err)
+	}
+}
@@ -0,0 +1,44 @@
+package xgrpc_conn
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// rawMessage is an already serialized protobuf message.
+type rawMessage []byte
+
+// rawCodec sends rawMessage values verbatim and falls back to
+// the protobuf encoding for any other message, responses included.
+// The extensions of the responses are resolved with types, the global ones when nil.
+type rawCodec struct {
+	types TypeResolver
+}
+
+// Marshal implements the encoding.Codec interface.
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch msg := v.(type) {
+	case rawMessage:
+		return msg, nil
+	case proto.Message:
+		return proto.Marshal(msg)
+	default:
+		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+}
+
+// Unmarshal implements the encoding.Codec interface.
+func (c rawCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
+	}
+	return proto.UnmarshalOptions{Resolver: c.types}.Unmarshal(data, msg)
+}
+
+// Name implements the encoding.Codec interface,
+// it matches the default codec so the content-type doesn't change.
+func (rawCodec) Name() string {
+	return "proto"
+}
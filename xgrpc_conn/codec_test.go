@@ -0,0 +1,30 @@
+package xgrpc_conn
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestRawCodec(t *testing.T) {
+	want, err := proto.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := rawCodec{}.Marshal(rawMessage(want))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("raw message must be sent verbatim, got %v, %v", got, err)
+	}
+	got, err = rawCodec{}.Marshal(wrapperspb.String("hello"))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("proto message must be marshaled, got %v, %v", got, err)
+	}
+
+	var msg wrapperspb.StringValue
+	if err = (rawCodec{}).Unmarshal(want, &msg); err != nil || msg.GetValue() != "hello" {
+		t.Fatalf("unexpected unmarshal result %v, %v", msg.GetValue(), err)
+	}
+}
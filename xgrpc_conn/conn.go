@@ -4,35 +4,94 @@ package xgrpc_conn
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"go.k6.io/k6/lib"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib"
 	"go.k6.io/k6/metrics"
 
 	protov1 "github.com/golang/protobuf/proto" //nolint:staticcheck,nolintlint // this is the old v1 version
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
 	grpcstats "google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
+// MessageFormat is the encoding of a request message.
+type MessageFormat int
+
+const (
+	// MessageFormatJSON is a protojson encoded message.
+	MessageFormatJSON MessageFormat = iota
+	// MessageFormatText is a prototext encoded message.
+	MessageFormatText
+	// MessageFormatBinary is an already serialized protobuf message, it is sent verbatim.
+	MessageFormatBinary
+)
+
+// JSONOptions configures the protojson encoding of the request and response messages.
+type JSONOptions struct {
+	UseProtoNames   bool
+	UseEnumNumbers  bool
+	EmitUnpopulated bool
+	DiscardUnknown  bool
+}
+
+// DefaultJSONOptions returns the options used by a request without JSONOptions.
+func DefaultJSONOptions() JSONOptions {
+	return JSONOptions{EmitUnpopulated: true}
+}
+
+func (o JSONOptions) marshalOptions(resolver TypeResolver) protojson.MarshalOptions {
+	return protojson.MarshalOptions{
+		Resolver:        resolver,
+		UseProtoNames:   o.UseProtoNames,
+		UseEnumNumbers:  o.UseEnumNumbers,
+		EmitUnpopulated: o.EmitUnpopulated,
+	}
+}
+
+func (o JSONOptions) unmarshalOptions(resolver TypeResolver) protojson.UnmarshalOptions {
+	return protojson.UnmarshalOptions{Resolver: resolver, DiscardUnknown: o.DiscardUnknown}
+}
+
 // Request represents a gRPC request.
 type Request struct {
 	MethodDescriptor protoreflect.MethodDescriptor
 	TagsAndMeta      *metrics.TagsAndMeta
 	Message          []byte
+	Format           MessageFormat
+	// JSONOptions are the protojson options, DefaultJSONOptions are used when nil.
+	JSONOptions *JSONOptions
+	// Types resolves the google.protobuf.Any messages of the request, the response
+	// and the error details, protoregistry.GlobalTypes is used when nil.
+	Types TypeResolver
+	// ProtoMessage is an already built request message,
+	// when it is set Message and Format are ignored.
+	ProtoMessage           proto.Message
+	DiscardResponseMessage bool
+	// ProtoResponse leaves the conversion of the response message to the caller,
+	// only Response.ProtoMessage is set.
+	ProtoResponse bool
+	// Signer signs the serialized request into the metadata, when set.
+	Signer *Signer
 }
 
 type RequestTime struct {
@@ -41,58 +100,174 @@ type RequestTime struct {
 
 // Response represents a gRPC response.
 type Response struct {
-	Message  interface{}
-	Error    interface{}
-	Headers  map[string][]string
-	Trailers map[string][]string
-	Status   codes.Code
-	Duration *float64
+	Message      interface{}
+	ProtoMessage proto.Message
+	Error        interface{}
+	Headers      map[string][]string
+	Trailers     map[string][]string
+	Status       codes.Code
+	Duration     *float64
 }
 
 type clientConnCloser interface {
 	grpc.ClientConnInterface
+	GetState() connectivity.State
+	WaitForStateChange(ctx context.Context, sourceState connectivity.State) bool
+	Connect()
 	Close() error
 }
 
 // Conn is a gRPC client connection.
 type Conn struct {
 	raw clientConnCloser
+
+	// the target and the options of the connection, for the connections of the other authorities
+	addr    string
+	options []grpc.DialOption
+
+	mu          sync.Mutex
+	authorities map[string]*Conn
+	// track is called with the connections of the other authorities, set by StateTracker.Track.
+	track func(*Conn)
 }
 
+// MaxAuthorities is the maximum number of connections opened by WithAuthority for a connection.
+const MaxAuthorities = 16
+
 // DefaultOptions generates an option set
 // with common options for requests from a VU.
+// They are the options of LazyOptions, Dial blocks until the connection is ready on its own.
+//
+// Deprecated: set the Options of Connect, e.g. Sink to VUMetricsSink and Dialer to VUDialer.
 func DefaultOptions(vu modules.VU) []grpc.DialOption {
-	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
-		return vu.State().Dialer.DialContext(ctx, "tcp", addr)
-	}
+	return LazyOptions(vu)
+}
 
+// LazyOptions generates the option set of DefaultOptions for NewClient,
+// without the options blocking the dial.
+//
+// Deprecated: set the Options of Connect with Lazy, e.g. Sink to VUMetricsSink and Dialer to VUDialer.
+func LazyOptions(vu modules.VU) []grpc.DialOption {
 	return []grpc.DialOption{
-		grpc.WithBlock(),
-		grpc.FailOnNonTempDialError(true),
-		grpc.WithReturnConnectionError(),
-		grpc.WithStatsHandler(statsHandler{vu: vu}),
-		grpc.WithContextDialer(dialer),
+		grpc.WithStatsHandler(statsHandler{sink: VUMetricsSink(vu)}),
+		grpc.WithContextDialer(ProxyDialer(VUProxyConfig(vu, ProxyFromEnvironment()), VUDialer(vu))),
+	}
+}
+
+// VUDialer returns the dialer of the connections of a VU, through the VU's dialer.
+// The unix and unix-abstract targets are dialed as unix sockets, see UnixSocket.
+func VUDialer(vu modules.VU) DialFunc {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		if socket, ok := UnixSocket(addr); ok {
+			return dialUnix(ctx, vu.State().Dialer, socket)
+		}
+		return vu.State().Dialer.DialContext(ctx, "tcp", addr)
 	}
 }
 
-// Dial establish a gRPC connection.
+// Dial establishes a gRPC connection, it blocks until the connection is ready and fails
+// when an attempt to connect fails or when ctx is done.
 func Dial(ctx context.Context, addr string, options ...grpc.DialOption) (*Conn, error) {
-	conn, err := grpc.DialContext(ctx, addr, options...)
+	return dial(ctx, addr, nil, options...)
+}
+
+// dial is Dial reporting the error of the failed attempt returned by lastErr, when not nil.
+func dial(ctx context.Context, addr string, lastErr func() error, options ...grpc.DialOption) (*Conn, error) {
+	conn, err := NewClient(addr, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.waitReady(ctx, lastErr); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// waitReady connects and waits until the connection is ready.
+func (c *Conn) waitReady(ctx context.Context, lastErr func() error) error {
+	c.Connect()
+	for {
+		state := c.State()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			if lastErr != nil {
+				if err := lastErr(); err != nil {
+					return fmt.Errorf("failed to connect to %s: %w", c.addr, err)
+				}
+			}
+			return fmt.Errorf("failed to connect to %s: the connection is in the %s state", c.addr, state)
+		}
+		if !c.WaitForStateChange(ctx, state) {
+			if lastErr != nil {
+				if err := lastErr(); err != nil {
+					return fmt.Errorf("failed to connect to %s: %w: %w", c.addr, ctx.Err(), err)
+				}
+			}
+			return fmt.Errorf("failed to connect to %s: %w", c.addr, ctx.Err())
+		}
+	}
+}
+
+// NewClient creates a gRPC connection without connecting, it is established by the first request
+// or by Connect and reestablished in the background when lost.
+// As with Dial, an address without a registered resolver scheme is dialed as it is.
+func NewClient(addr string, options ...grpc.DialOption) (*Conn, error) {
+	conn, err := grpc.NewClient(passthroughTarget(addr), options...)
 	if err != nil {
 		return nil, err
 	}
 	return &Conn{
-		raw: conn,
+		raw:     conn,
+		addr:    addr,
+		options: options,
 	}, nil
 }
 
-// Reflect returns using the reflection the FileDescriptorSet describing the service.
-func (c *Conn) Reflect(ctx context.Context) (*descriptorpb.FileDescriptorSet, error) {
+// passthroughTarget returns the target of the address, with the passthrough scheme
+// when it has no registered one. NewClient uses dns by default, it would bypass the VU's dialer resolution.
+func passthroughTarget(addr string) string {
+	if u, err := url.Parse(addr); err == nil && u.Scheme != "" && resolver.Get(u.Scheme) != nil {
+		return addr
+	}
+	return "passthrough:///" + addr
+}
+
+// State returns the connectivity state of the connection.
+func (c *Conn) State() connectivity.State {
+	return c.raw.GetState()
+}
+
+// Connect starts connecting when the connection is idle.
+func (c *Conn) Connect() {
+	c.raw.Connect()
+}
+
+// WaitForStateChange waits until the connectivity state is not source or the context is done,
+// it returns whether the state changed.
+func (c *Conn) WaitForStateChange(ctx context.Context, source connectivity.State) bool {
+	return c.raw.WaitForStateChange(ctx, source)
+}
+
+// Reflect returns using the reflection the FileDescriptorSet describing the services,
+// all the services exposed by the server when none is given.
+func (c *Conn) Reflect(ctx context.Context, services ...string) (*descriptorpb.FileDescriptorSet, error) {
 	rc := reflectionClient{Conn: c.raw}
-	return rc.Reflect(ctx)
+	return rc.Reflect(ctx, services...)
 }
 
-// Invoke executes a unary gRPC request.
+// ListServices returns using the reflection the names of the services exposed by the server.
+func (c *Conn) ListServices(ctx context.Context) ([]string, error) {
+	rc := reflectionClient{Conn: c.raw}
+	return rc.ListServices(ctx)
+}
+
+// Invoke executes a unary gRPC request, the response message is discarded
+// with the DiscardResponseBodies option.
+//
+// Deprecated: use InvokeRequest and the DiscardResponseMessage of the request.
 func (c *Conn) Invoke(
 	ctx context.Context,
 	options lib.Options,
@@ -100,6 +275,20 @@ func (c *Conn) Invoke(
 	md metadata.MD,
 	req Request,
 	opts ...grpc.CallOption,
+) (*Response, error) {
+	if options.DiscardResponseBodies.Bool {
+		req.DiscardResponseMessage = true
+	}
+	return c.InvokeRequest(ctx, url, md, req, opts...)
+}
+
+// InvokeRequest executes a unary gRPC request.
+func (c *Conn) InvokeRequest(
+	ctx context.Context,
+	url string,
+	md metadata.MD,
+	req Request,
+	opts ...grpc.CallOption,
 ) (*Response, error) {
 	if url == "" {
 		return nil, fmt.Errorf("url is required")
@@ -107,16 +296,22 @@ func (c *Conn) Invoke(
 	if req.MethodDescriptor == nil {
 		return nil, fmt.Errorf("request method descriptor is required")
 	}
-	if len(req.Message) == 0 {
+	if len(req.Message) == 0 && req.Format == MessageFormatJSON && req.ProtoMessage == nil {
 		return nil, fmt.Errorf("request message is required")
 	}
 
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	reqdm := dynamicpb.NewMessage(req.MethodDescriptor.Input())
-	if err := protojson.Unmarshal(req.Message, reqdm); err != nil {
+	reqmsg, err := req.decodeMessage()
+	if err != nil {
 		return nil, fmt.Errorf("unable to serialise request object to protocol buffer: %w", err)
 	}
+	if req.Signer != nil {
+		md = md.Copy()
+		if reqmsg, err = req.signRequest(url, reqmsg, md); err != nil {
+			return nil, fmt.Errorf("unable to sign the request: %w", err)
+		}
+	}
+
+	ctx = metadata.NewOutgoingContext(ctx, md)
 
 	ctx = withRPCState(ctx, &rpcState{tagsAndMeta: req.TagsAndMeta})
 	ctx = withRequestTime(ctx, &RequestTime{Duration: nil})
@@ -124,11 +319,21 @@ func (c *Conn) Invoke(
 	resp := dynamicpb.NewMessage(req.MethodDescriptor.Output())
 	header, trailer := metadata.New(nil), metadata.New(nil)
 
-	copts := make([]grpc.CallOption, 0, len(opts)+2)
+	copts := make([]grpc.CallOption, 0, len(opts)+3)
 	copts = append(copts, opts...)
 	copts = append(copts, grpc.Header(&header), grpc.Trailer(&trailer))
+	if _, ok := reqmsg.(rawMessage); ok {
+		// the serialized requests are protobuf, they can't be sent with another codec
+		for _, o := range opts {
+			if subtype, ok := o.(grpc.ContentSubtypeCallOption); ok && subtype.ContentSubtype != "proto" {
+				return nil, fmt.Errorf("the signed and binary requests are sent as protobuf, not as %q",
+					subtype.ContentSubtype)
+			}
+		}
+		copts = append(copts, grpc.ForceCodec(rawCodec{types: req.resolver()}))
+	}
 
-	err := c.raw.Invoke(ctx, url, reqdm, resp, copts...)
+	err = c.raw.Invoke(ctx, url, reqmsg, resp, copts...)
 
 	response := Response{
 		Headers:  header,
@@ -136,7 +341,7 @@ func (c *Conn) Invoke(
 		Duration: getGrpcRequestTime(ctx).Duration,
 	}
 
-	marshaler := protojson.MarshalOptions{EmitUnpopulated: true}
+	marshaler := req.jsonOptions().marshalOptions(req.resolver())
 
 	if err != nil {
 		sterr := status.Convert(err)
@@ -147,11 +352,7 @@ func (c *Conn) Invoke(
 		// not map back to a "real" field or value (as a normal Go type would). If we don't marshal and then
 		// unmarshal back to a map, you will get "undefined" when accessing JSON properties, even when
 		// JSON.Stringify() shows the object to be correctly present.
-
-		raw, _ := marshaler.Marshal(sterr.Proto())
-		errMsg := make(map[string]interface{})
-		_ = json.Unmarshal(raw, &errMsg)
-		response.Error = errMsg
+		response.Error = statusToMap(sterr, marshaler)
 	}
 
 	if resp != nil {
@@ -169,23 +370,108 @@ func (c *Conn) Invoke(
 		// {"x":6,"y":4}
 		// rather than the desired:
 		// {"x":6,"y":4,"z":0}
-		if !options.DiscardResponseBodies.Bool {
-			raw, _ := marshaler.Marshal(resp)
-			msg := make(map[string]interface{})
-			_ = json.Unmarshal(raw, &msg)
-			response.Message = msg
+		if !req.DiscardResponseMessage {
+			response.ProtoMessage = resp
+			if !req.ProtoResponse {
+				raw, _ := marshaler.Marshal(resp)
+				msg := make(map[string]interface{})
+				_ = json.Unmarshal(raw, &msg)
+				response.Message = msg
+			}
 		}
 	}
 	return &response, nil
 }
 
+// resolver returns the resolver of the google.protobuf.Any types.
+func (req Request) resolver() TypeResolver {
+	if req.Types == nil {
+		return protoregistry.GlobalTypes
+	}
+	return req.Types
+}
+
+func (req Request) jsonOptions() JSONOptions {
+	if req.JSONOptions == nil {
+		return DefaultJSONOptions()
+	}
+	return *req.JSONOptions
+}
+
+// decodeMessage returns the message to send according to the request format.
+func (req Request) decodeMessage() (interface{}, error) {
+	if req.ProtoMessage != nil {
+		return req.ProtoMessage, nil
+	}
+	if req.Format == MessageFormatBinary {
+		return rawMessage(req.Message), nil
+	}
+
+	reqdm := dynamicpb.NewMessage(req.MethodDescriptor.Input())
+	opts := req.jsonOptions()
+	var err error
+	if req.Format == MessageFormatText {
+		err = prototext.UnmarshalOptions{
+			Resolver:       req.resolver(),
+			DiscardUnknown: opts.DiscardUnknown,
+		}.Unmarshal(req.Message, reqdm)
+	} else {
+		err = opts.unmarshalOptions(req.resolver()).Unmarshal(req.Message, reqdm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reqdm, nil
+}
+
 // Close closes the underhood connection.
 func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for authority, conn := range c.authorities {
+		_ = conn.Close()
+		delete(c.authorities, authority)
+	}
 	return c.raw.Close()
 }
 
+// WithAuthority returns the connection to the same target using the authority as the :authority
+// header, and the TLS server name, instead of the target's host. grpc-go has no call option
+// for it, so a lazy connection with the same options is created per authority and kept until Close,
+// at most MaxAuthorities of them. They are tracked by the StateTracker tracking c.
+func (c *Conn) WithAuthority(authority string) (*Conn, error) {
+	if authority == "" {
+		return c, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.authorities[authority]; ok {
+		return conn, nil
+	}
+	if c.addr == "" {
+		return nil, errors.New("the authority can't be changed on this connection")
+	}
+	if len(c.authorities) >= MaxAuthorities {
+		return nil, fmt.Errorf("too many authorities, a connection to %s can use at most %d", c.addr, MaxAuthorities)
+	}
+	options := append(append([]grpc.DialOption(nil), c.options...), grpc.WithAuthority(authority))
+	conn, err := NewClient(c.addr, options...)
+	if err != nil {
+		return nil, err
+	}
+	if c.authorities == nil {
+		c.authorities = make(map[string]*Conn)
+	}
+	c.authorities[authority] = conn
+	if c.track != nil {
+		c.track(conn)
+	}
+	return conn, nil
+}
+
+// statsHandler measures the RPCs of a connection and passes their stats to the sink.
 type statsHandler struct {
-	vu modules.VU
+	sink MetricsSink
 }
 
 // TagConn implements the grpcstats.Handler interface
@@ -206,13 +492,37 @@ func (statsHandler) TagRPC(ctx context.Context, _ *grpcstats.RPCTagInfo) context
 
 // HandleRPC implements the grpcstats.Handler interface
 func (h statsHandler) HandleRPC(ctx context.Context, stat grpcstats.RPCStats) {
+	// the requests made outside of Invoke, e.g. the reflection ones, aren't measured
+	if end, ok := stat.(*grpcstats.End); ok {
+		if requestTime := getGrpcRequestTime(ctx); requestTime != nil {
+			d := metrics.D(end.EndTime.Sub(end.BeginTime))
+			requestTime.Duration = &d
+		}
+	}
+	if h.sink != nil {
+		h.sink.HandleRPC(ctx, stat)
+	}
+}
+
+// vuSink pushes the metrics of the RPCs to the VU and logs them with --http-debug.
+type vuSink struct {
+	vu modules.VU
+}
+
+// VUMetricsSink returns the sink of the RPC stats of a VU: it pushes the grpc_req_duration metric,
+// tagged as the VU's requests, and logs the RPCs when --http-debug is enabled.
+func VUMetricsSink(vu modules.VU) MetricsSink {
+	return vuSink{vu: vu}
+}
+
+// HandleRPC implements the MetricsSink interface
+func (h vuSink) HandleRPC(ctx context.Context, stat grpcstats.RPCStats) {
 	state := h.vu.State()
 	stateRPC := getRPCState(ctx) //nolint:ifshort
-	grpcRequestTime := getGrpcRequestTime(ctx)
 
 	// If the request is done by the reflection handler then the tags will be
 	// nil. In this case, we can reuse the VU.State's Tags.
-	if stateRPC == nil {
+	if stateRPC == nil || stateRPC.tagsAndMeta == nil {
 		// TODO: investigate this more, there has to be a way to fix it :/
 		ctm := state.Tags.GetCurrentValues()
 		stateRPC = &rpcState{tagsAndMeta: &ctm}
@@ -221,6 +531,7 @@ func (h statsHandler) HandleRPC(ctx context.Context, stat grpcstats.RPCStats) {
 	switch s := stat.(type) {
 	case *grpcstats.OutHeader:
 		// TODO: figure out something better, e.g. via TagConn() or TagRPC()?
+		// the unix sockets have no IP, the tag is left unset for them
 		if state.Options.SystemTags.Has(metrics.TagIP) && s.RemoteAddr != nil {
 			if ip, _, err := net.SplitHostPort(s.RemoteAddr.String()); err == nil {
 				stateRPC.tagsAndMeta.SetSystemTagOrMeta(metrics.TagIP, ip)
@@ -231,8 +542,6 @@ func (h statsHandler) HandleRPC(ctx context.Context, stat grpcstats.RPCStats) {
 			stateRPC.tagsAndMeta.SetSystemTagOrMeta(metrics.TagStatus, strconv.Itoa(int(status.Code(s.Error))))
 		}
 
-		ss := metrics.D(s.EndTime.Sub(s.BeginTime))
-		grpcRequestTime.Duration = &ss
 		metrics.PushIfNotDone(ctx, state.Samples, metrics.Sample{
 			TimeSeries: metrics.TimeSeries{
 				Metric: state.BuiltinMetrics.GRPCReqDuration,
@@ -0,0 +1,196 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"go.k6.io/k6/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestPassthroughTarget(t *testing.T) {
+	t.Parallel()
+
+	for addr, expected := range map[string]string{
+		"localhost:8080":     "passthrough:///localhost:8080",
+		"10.0.0.1:443":       "passthrough:///10.0.0.1:443",
+		"dns:///example:443": "dns:///example:443",
+		"unknown:///x:1":     "passthrough:///unknown:///x:1",
+	} {
+		if got := passthroughTarget(addr); got != expected {
+			t.Errorf("unexpected target %q of %q, expected %q", got, addr, expected)
+		}
+	}
+}
+
+func TestNewClientConnectivity(t *testing.T) {
+	t.Parallel()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := NewClient("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	if s := conn.State(); s != connectivity.Idle {
+		t.Fatalf("a new client should be idle, got %s", s)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn.Connect()
+	for s := conn.State(); s != connectivity.Ready; s = conn.State() {
+		if !conn.WaitForStateChange(ctx, s) {
+			t.Fatalf("the connection isn't ready, its state is %s", s)
+		}
+	}
+}
+
+func TestConnectBlocking(t *testing.T) {
+	t.Parallel()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Connect(ctx, "bufnet", Options{
+		Dialer: func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if state := conn.State(); state != connectivity.Ready {
+		t.Fatalf("the connection should be ready, got %s", state)
+	}
+
+	// a failed attempt fails the dial with its error, before the deadline
+	refused := errors.New("refused by the test")
+	start := time.Now()
+	_, err = Connect(ctx, "bufnet", Options{
+		Dialer: func(context.Context, string) (net.Conn, error) { return nil, refused },
+	})
+	if !errors.Is(err, refused) {
+		t.Fatalf("expected the error of the dialer, got %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatal("the dial should fail with the first failed attempt")
+	}
+
+	// the dial is bounded by the context
+	blocked, cancelBlocked := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelBlocked()
+	_, err = Connect(blocked, "bufnet", Options{
+		Dialer: func(ctx context.Context, _ string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline of the context, got %v", err)
+	}
+}
+
+func TestConnWithAuthority(t *testing.T) {
+	t.Parallel()
+
+	lis := bufconn.Listen(1 << 20)
+	authorities := make(chan string, 2)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(
+		ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		authorities <- md.Get(":authority")[0]
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := NewClient("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	registry := metrics.NewRegistry()
+	samples := make(chan metrics.SampleContainer, 64)
+	tracker := &StateTracker{
+		Target:  "bufnet",
+		Metrics: StateMetrics{Transitions: registry.MustNewMetric("transitions", metrics.Counter)},
+		Samples: samples,
+		Tags:    registry.RootTagSet(),
+	}
+	trackCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tracker.Track(trackCtx, conn)
+
+	other, err := conn.WithAuthority("api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := conn.WithAuthority("api.example.com"); again != other {
+		t.Fatal("the connection of an authority should be reused")
+	}
+	if same, _ := conn.WithAuthority(""); same != conn {
+		t.Fatal("an empty authority should return the connection")
+	}
+
+	ctx := context.Background()
+	for _, c := range []*Conn{conn, other} {
+		if _, err = c.HealthCheck(ctx, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := <-authorities; got != "bufnet" {
+		t.Errorf("unexpected default authority %q", got)
+	}
+	if got := <-authorities; got != "api.example.com" {
+		t.Errorf("unexpected authority %q", got)
+	}
+
+	// the connection of the authority is tracked too, IDLE -> CONNECTING -> READY for both
+	for ready := 0; ready < 2; {
+		select {
+		case c := <-samples:
+			for _, s := range c.GetSamples() {
+				if to, _ := s.Tags.Get("to"); to == "READY" {
+					ready++
+				}
+			}
+		case <-trackCtx.Done():
+			t.Fatal("the transitions of both connections should be tracked")
+		}
+	}
+
+	for i := 1; i < MaxAuthorities; i++ {
+		if _, err = conn.WithAuthority(fmt.Sprintf("api-%d.example.com", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = conn.WithAuthority("one-too-many.example.com"); err == nil {
+		t.Fatal("expected an error past MaxAuthorities")
+	}
+}
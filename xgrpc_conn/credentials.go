@@ -0,0 +1,394 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// maxTokenRefreshMargin is how long before its expiry a token is refreshed at most,
+	// the short-lived tokens are refreshed when 90% of their lifetime elapsed.
+	maxTokenRefreshMargin = time.Minute
+	defaultJWTLifetime    = time.Hour
+	// minTokenRetryDelay is how long no token is requested after a failure,
+	// doubled with each consecutive failure up to maxTokenRetryDelay.
+	minTokenRetryDelay = time.Second
+	maxTokenRetryDelay = 30 * time.Second
+)
+
+// Token is an access token sent as the authorization metadata of the RPCs.
+type Token struct {
+	Value string
+	// Type is the authorization scheme, Bearer when empty.
+	Type string
+	// Expiry is when the token expires, it doesn't when zero.
+	Expiry time.Time
+	// issued is when the token was obtained, for its refresh margin.
+	issued time.Time
+}
+
+// refreshAt returns when the token should be refreshed.
+func (t Token) refreshAt() time.Time {
+	margin := t.Expiry.Sub(t.issued) / 10
+	if margin > maxTokenRefreshMargin || margin < 0 {
+		margin = maxTokenRefreshMargin
+	}
+	return t.Expiry.Add(-margin)
+}
+
+// TokenSource returns the tokens of the RPCs.
+type TokenSource interface {
+	Token(ctx context.Context) (Token, error)
+}
+
+// StaticToken returns a source of a token which doesn't expire.
+func StaticToken(token string) TokenSource {
+	return staticToken(token)
+}
+
+type staticToken string
+
+func (t staticToken) Token(context.Context) (Token, error) {
+	return Token{Value: string(t)}, nil
+}
+
+// cachedTokens caches the token of a source until it is about to expire.
+type cachedTokens struct {
+	source TokenSource
+	mu     sync.Mutex
+	token  Token
+	// refreshing is closed when the token being requested is received, nil when none is.
+	refreshing chan struct{}
+	// err is the error of the last request, returned until retryAt.
+	err      error
+	retryAt  time.Time
+	failures int
+}
+
+// CachedTokens returns the source caching the tokens of source, a new token is
+// requested shortly before the current one expires. A single token is requested at a
+// time, the callers without a valid token wait for it, and the requests are delayed
+// after a failure.
+func CachedTokens(source TokenSource) TokenSource {
+	return &cachedTokens{source: source}
+}
+
+func (c *cachedTokens) Token(ctx context.Context) (Token, error) {
+	c.mu.Lock()
+	for {
+		now := time.Now()
+		fresh := c.token.Value != "" && (c.token.Expiry.IsZero() || now.Before(c.token.refreshAt()))
+		// the current token is still used until it expires
+		valid := c.token.Value != "" && now.Before(c.token.Expiry)
+		switch {
+		case fresh, valid && (c.refreshing != nil || now.Before(c.retryAt)):
+			token := c.token
+			c.mu.Unlock()
+			return token, nil
+		case c.refreshing != nil:
+			refreshing := c.refreshing
+			c.mu.Unlock()
+			select {
+			case <-refreshing:
+			case <-ctx.Done():
+				return Token{}, ctx.Err()
+			}
+			c.mu.Lock()
+			continue
+		case now.Before(c.retryAt):
+			err := c.err
+			c.mu.Unlock()
+			return Token{}, err
+		}
+
+		refreshing := make(chan struct{})
+		c.refreshing = refreshing
+		c.mu.Unlock()
+		token, err := c.source.Token(ctx)
+		c.mu.Lock()
+		c.refreshing = nil
+		close(refreshing)
+		if err != nil {
+			// the caller giving up isn't a failure of the source
+			if ctx.Err() == nil {
+				c.failures++
+				c.err, c.retryAt = err, time.Now().Add(tokenRetryDelay(c.failures))
+			}
+			token = c.token
+			c.mu.Unlock()
+			if valid {
+				return token, nil
+			}
+			return Token{}, err
+		}
+		if token.issued.IsZero() {
+			token.issued = now
+		}
+		c.token, c.err, c.retryAt, c.failures = token, nil, time.Time{}, 0
+		c.mu.Unlock()
+		return token, nil
+	}
+}
+
+// tokenRetryDelay returns how long no token is requested after the failures.
+func tokenRetryDelay(failures int) time.Duration {
+	delay := minTokenRetryDelay
+	for i := 1; i < failures && delay < maxTokenRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxTokenRetryDelay {
+		delay = maxTokenRetryDelay
+	}
+	return delay
+}
+
+// ClientCredentials are the settings of the OAuth2 client credentials grant.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Params are sent with the token request, e.g. the audience.
+	Params map[string]string
+	// SecretInBody sends the client id and secret in the request body instead of the basic authentication.
+	SecretInBody bool
+	// HTTPClient requests the tokens, http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// Token requests a token from the token endpoint.
+func (cc ClientCredentials) Token(ctx context.Context) (Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cc.Scopes) > 0 {
+		form.Set("scope", strings.Join(cc.Scopes, " "))
+	}
+	for k, v := range cc.Params {
+		form.Set(k, v)
+	}
+	if cc.SecretInBody {
+		form.Set("client_id", cc.ClientID)
+		form.Set("client_secret", cc.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cc.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !cc.SecretInBody {
+		req.SetBasicAuth(url.QueryEscape(cc.ClientID), url.QueryEscape(cc.ClientSecret))
+	}
+
+	client := cc.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	issued := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("token request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Token{}, fmt.Errorf("token request failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Token{}, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	var tr struct {
+		AccessToken string      `json:"access_token"`
+		TokenType   string      `json:"token_type"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &tr); err != nil {
+		return Token{}, fmt.Errorf("invalid token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return Token{}, errors.New("invalid token response: no access_token")
+	}
+	token := Token{Value: tr.AccessToken, Type: tr.TokenType, issued: issued}
+	if tr.ExpiresIn != "" {
+		seconds, err := tr.ExpiresIn.Int64()
+		if err != nil {
+			return Token{}, fmt.Errorf("invalid token response: expires_in: %w", err)
+		}
+		if seconds > 0 {
+			token.Expiry = issued.Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	return token, nil
+}
+
+// JWT are the settings of the JSON Web Tokens signed locally.
+type JWT struct {
+	// Algorithm is HS256 or RS256.
+	Algorithm string
+	// Key is the HMAC secret or the PEM encoded RSA private key.
+	Key     []byte
+	KeyID   string
+	Issuer  string
+	Subject string
+	// Audience is the aud claim, a string for a single audience.
+	Audience []string
+	// Claims are added to the registered ones.
+	Claims map[string]interface{}
+	// Lifetime is the validity of a token, an hour when zero.
+	Lifetime time.Duration
+}
+
+// jwtSigner signs the tokens of a JWT config.
+type jwtSigner struct {
+	config JWT
+	sign   func(data []byte) ([]byte, error)
+}
+
+// TokenSource returns the source of the tokens signed with the config.
+func (j JWT) TokenSource() (TokenSource, error) {
+	key := j.Key
+	if len(key) == 0 {
+		return nil, errors.New("the JWT key is required")
+	}
+
+	s := &jwtSigner{config: j}
+	switch j.Algorithm {
+	case "HS256":
+		s.sign = func(data []byte) ([]byte, error) {
+			mac := hmac.New(sha256.New, key)
+			mac.Write(data)
+			return mac.Sum(nil), nil
+		}
+	case "RS256":
+		rsaKey, err := parseRSAPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		s.sign = func(data []byte) ([]byte, error) {
+			digest := sha256.Sum256(data)
+			return rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q, it needs to be HS256 or RS256", j.Algorithm)
+	}
+	return s, nil
+}
+
+func parseRSAPrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("the RSA key needs to be PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RSA private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key is a %T, not an RSA one", key)
+	}
+	return rsaKey, nil
+}
+
+func (s *jwtSigner) Token(context.Context) (Token, error) {
+	now := time.Now()
+	lifetime := s.config.Lifetime
+	if lifetime <= 0 {
+		lifetime = defaultJWTLifetime
+	}
+	expiry := now.Add(lifetime)
+
+	header := map[string]interface{}{"alg": s.config.Algorithm, "typ": "JWT"}
+	if s.config.KeyID != "" {
+		header["kid"] = s.config.KeyID
+	}
+	claims := make(map[string]interface{}, len(s.config.Claims)+5)
+	for k, v := range s.config.Claims {
+		claims[k] = v
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = expiry.Unix()
+	if s.config.Issuer != "" {
+		claims["iss"] = s.config.Issuer
+	}
+	if s.config.Subject != "" {
+		claims["sub"] = s.config.Subject
+	}
+	switch len(s.config.Audience) {
+	case 0:
+	case 1:
+		claims["aud"] = s.config.Audience[0]
+	default:
+		claims["aud"] = s.config.Audience
+	}
+
+	var parts []string
+	for _, part := range []interface{}{header, claims} {
+		b, err := json.Marshal(part)
+		if err != nil {
+			return Token{}, err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(b))
+	}
+	signingInput := strings.Join(parts, ".")
+	sig, err := s.sign([]byte(signingInput))
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{
+		Value:  signingInput + "." + base64.RawURLEncoding.EncodeToString(sig),
+		Expiry: expiry,
+		issued: now,
+	}, nil
+}
+
+// tokenCredentials are the per-RPC credentials sending the tokens of a source.
+type tokenCredentials struct {
+	source        TokenSource
+	allowInsecure bool
+}
+
+// PerRPCCredentials returns the credentials sending the tokens of the source as the authorization
+// metadata. The credentials require a TLS connection unless allowInsecure is set.
+func PerRPCCredentials(source TokenSource, allowInsecure bool) credentials.PerRPCCredentials {
+	return tokenCredentials{source: source, allowInsecure: allowInsecure}
+}
+
+// GetRequestMetadata implements the credentials.PerRPCCredentials interface
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := c.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scheme := token.Type
+	if scheme == "" || strings.EqualFold(scheme, "bearer") {
+		scheme = "Bearer"
+	}
+	return map[string]string{"authorization": scheme + " " + token.Value}, nil
+}
+
+// RequireTransportSecurity implements the credentials.PerRPCCredentials interface
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return !c.allowInsecure
+}
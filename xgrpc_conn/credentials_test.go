@@ -0,0 +1,271 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestClientCredentials(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "s3cret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" ||
+			r.FormValue("audience") != "api" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, n)
+	}))
+	t.Cleanup(srv.Close)
+
+	cc := ClientCredentials{
+		TokenURL:     srv.URL,
+		ClientID:     "client",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"read", "write"},
+		Params:       map[string]string{"audience": "api"},
+	}
+	source := CachedTokens(cc).(*cachedTokens)
+	creds := PerRPCCredentials(source, true)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		md, err := creds.GetRequestMetadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md["authorization"] != "Bearer token-1" {
+			t.Fatalf("unexpected authorization %q", md["authorization"])
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("the token should be cached, got %d requests", n)
+	}
+
+	// the token is refreshed shortly before it expires
+	source.token.issued = time.Now().Add(-time.Hour)
+	source.token.Expiry = time.Now().Add(time.Second)
+	token, err := source.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Value != "token-2" {
+		t.Fatalf("the token should be refreshed, got %q", token.Value)
+	}
+
+	// a failed refresh keeps the token which didn't expire yet
+	source.source = ClientCredentials{TokenURL: srv.URL, ClientID: "other"}
+	source.token.issued = time.Now().Add(-time.Hour)
+	source.token.Expiry = time.Now().Add(time.Second)
+	if token, err = source.Token(ctx); err != nil || token.Value != "token-2" {
+		t.Fatalf("the current token should be kept, got %q %v", token.Value, err)
+	}
+	source.token.Expiry = time.Now().Add(-time.Second)
+	if _, err = source.Token(ctx); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected the status of the failed request, got %v", err)
+	}
+}
+
+// slowTokens returns its tokens, or err, after a delay.
+type slowTokens struct {
+	calls atomic.Int32
+	err   error
+}
+
+func (s *slowTokens) Token(context.Context) (Token, error) {
+	n := s.calls.Add(1)
+	time.Sleep(50 * time.Millisecond)
+	if s.err != nil {
+		return Token{}, s.err
+	}
+	return Token{Value: fmt.Sprintf("token-%d", n), Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestCachedTokens(t *testing.T) {
+	t.Parallel()
+
+	slow := &slowTokens{}
+	source := CachedTokens(slow)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := source.Token(context.Background()); err != nil || token.Value != "token-1" {
+				t.Errorf("unexpected token %q %v", token.Value, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := slow.calls.Load(); n != 1 {
+		t.Fatalf("a single token should be requested, got %d requests", n)
+	}
+
+	// no token is requested again right after a failure
+	failing := &slowTokens{err: errors.New("unavailable")}
+	source = CachedTokens(failing)
+	for i := 0; i < 3; i++ {
+		if _, err := source.Token(context.Background()); err == nil {
+			t.Fatal("expected the error of the source")
+		}
+	}
+	if n := failing.calls.Load(); n != 1 {
+		t.Fatalf("the requests should be delayed after a failure, got %d requests", n)
+	}
+	if d := tokenRetryDelay(1); d != minTokenRetryDelay {
+		t.Errorf("unexpected first delay %s", d)
+	}
+	if d := tokenRetryDelay(100); d != maxTokenRetryDelay {
+		t.Errorf("the delay should be capped, got %s", d)
+	}
+}
+
+func TestJWT(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	verify := map[string]func(input, sig []byte) error{
+		"HS256": func(input, sig []byte) error {
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write(input)
+			if !hmac.Equal(sig, mac.Sum(nil)) {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		},
+		"RS256": func(input, sig []byte) error {
+			digest := sha256.Sum256(input)
+			return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig)
+		},
+	}
+	for alg, config := range map[string]JWT{
+		"HS256": {Algorithm: "HS256", Key: []byte("secret")},
+		"RS256": {Algorithm: "RS256", Key: pemKey, KeyID: "k1"},
+	} {
+		config.Issuer, config.Audience, config.Lifetime = "k6", []string{"api"}, time.Minute
+		config.Claims = map[string]interface{}{"scope": "read"}
+		source, err := config.TokenSource()
+		if err != nil {
+			t.Fatal(alg, err)
+		}
+		token, err := source.Token(context.Background())
+		if err != nil {
+			t.Fatal(alg, err)
+		}
+		parts := strings.Split(token.Value, ".")
+		if len(parts) != 3 {
+			t.Fatalf("%s: invalid token %q", alg, token.Value)
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			t.Fatal(alg, err)
+		}
+		if err = verify[alg]([]byte(parts[0]+"."+parts[1]), sig); err != nil {
+			t.Fatal(alg, err)
+		}
+
+		var header, claims map[string]interface{}
+		for i, v := range []*map[string]interface{}{&header, &claims} {
+			b, err := base64.RawURLEncoding.DecodeString(parts[i])
+			if err != nil {
+				t.Fatal(alg, err)
+			}
+			if err = json.Unmarshal(b, v); err != nil {
+				t.Fatal(alg, err)
+			}
+		}
+		if header["alg"] != alg || header["typ"] != "JWT" {
+			t.Errorf("%s: unexpected header %v", alg, header)
+		}
+		if claims["iss"] != "k6" || claims["aud"] != "api" || claims["scope"] != "read" {
+			t.Errorf("%s: unexpected claims %v", alg, claims)
+		}
+		if exp := int64(claims["exp"].(float64)); exp != token.Expiry.Unix() {
+			t.Errorf("%s: exp %d doesn't match the expiry %s", alg, exp, token.Expiry)
+		}
+	}
+
+	for _, config := range []JWT{
+		{Algorithm: "HS256"},
+		{Algorithm: "ES256", Key: []byte("secret")},
+		{Algorithm: "RS256", Key: []byte("secret")},
+	} {
+		if _, err := config.TokenSource(); err == nil {
+			t.Errorf("%+v: expected an error", config)
+		}
+	}
+}
+
+func TestConnectPerRPCCredentials(t *testing.T) {
+	t.Parallel()
+
+	authorization := make(chan string, 1)
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.UnaryInterceptor(
+		func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			authorization <- strings.Join(md.Get("authorization"), ",")
+			return handler(ctx, req)
+		}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := Connect(context.Background(), "bufnet", Options{
+		Lazy:              true,
+		Dialer:            func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) },
+		PerRPCCredentials: PerRPCCredentials(StaticToken("static"), true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	if _, err = conn.HealthCheck(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-authorization; got != "Bearer static" {
+		t.Fatalf("unexpected authorization %q", got)
+	}
+}
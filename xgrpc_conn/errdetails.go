@@ -0,0 +1,40 @@
+package xgrpc_conn
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails" // registers the standard google.rpc error details
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// statusToMap converts a gRPC status into a generic map. The details are decoded
+// one by one with the types known by the marshaler's resolver (the standard
+// google.rpc error details and the loaded messages), a detail with an unknown type
+// is kept with its type URL and its base64 encoded value instead of failing the
+// whole conversion.
+func statusToMap(st *status.Status, marshaler protojson.MarshalOptions) map[string]interface{} {
+	spb := st.Proto()
+	details := make([]interface{}, 0, len(spb.GetDetails()))
+	for _, detail := range spb.GetDetails() {
+		var decoded map[string]interface{}
+		raw, err := marshaler.Marshal(detail)
+		if err == nil {
+			err = json.Unmarshal(raw, &decoded)
+		}
+		if err != nil {
+			decoded = map[string]interface{}{
+				"@type": detail.GetTypeUrl(),
+				"value": base64.StdEncoding.EncodeToString(detail.GetValue()),
+			}
+		}
+		details = append(details, decoded)
+	}
+
+	return map[string]interface{}{
+		"code":    spb.GetCode(),
+		"message": spb.GetMessage(),
+		"details": details,
+	}
+}
@@ -0,0 +1,46 @@
+package xgrpc_conn
+
+import (
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestStatusToMap(t *testing.T) {
+	st, err := status.New(codes.InvalidArgument, "bad request").WithDetails(
+		&errdetails.ErrorInfo{Reason: "QUOTA", Domain: "example.com"},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "name", Description: "required"},
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spb := st.Proto()
+	spb.Details = append(spb.Details, &anypb.Any{TypeUrl: "type.googleapis.com/unknown.Detail", Value: []byte{1, 2}})
+
+	m := statusToMap(status.FromProto(spb), protojson.MarshalOptions{EmitUnpopulated: true})
+	if m["code"] != int32(codes.InvalidArgument) || m["message"] != "bad request" {
+		t.Fatalf("unexpected status %v", m)
+	}
+	details := m["details"].([]interface{})
+	if len(details) != 3 {
+		t.Fatalf("expected 3 details, got %d", len(details))
+	}
+	info := details[0].(map[string]interface{})
+	if info["@type"] != "type.googleapis.com/google.rpc.ErrorInfo" || info["reason"] != "QUOTA" {
+		t.Errorf("unexpected ErrorInfo %v", info)
+	}
+	violations := details[1].(map[string]interface{})["fieldViolations"].([]interface{})
+	if violations[0].(map[string]interface{})["field"] != "name" {
+		t.Errorf("unexpected BadRequest %v", details[1])
+	}
+	unknown := details[2].(map[string]interface{})
+	if unknown["@type"] != "type.googleapis.com/unknown.Detail" || unknown["value"] != "AQI=" {
+		t.Errorf("unexpected unknown detail %v", unknown)
+	}
+}
@@ -0,0 +1,67 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	// registers the client-side health checking, enabled by the healthCheckConfig of the service config
+	_ "google.golang.org/grpc/health"
+)
+
+// HealthStatus is the serving status of a service, as reported by grpc.health.v1.
+type HealthStatus = healthpb.HealthCheckResponse_ServingStatus
+
+// HealthServiceConfig returns the service config of the round robin balancing,
+// with the client-side health checking of the service when enabled.
+// The balancer then only picks the backends reporting SERVING, an empty service is the server's status.
+func HealthServiceConfig(enabled bool, service string) string {
+	config := map[string]interface{}{"loadBalancingPolicy": "round_robin"}
+	if enabled {
+		config["healthCheckConfig"] = map[string]interface{}{"serviceName": service}
+	}
+	b, _ := json.Marshal(config) //nolint:errchkjson
+	return string(b)
+}
+
+// HealthCheck returns the serving status of the service, the overall status of the server
+// for an empty service. A service unknown to the server is SERVICE_UNKNOWN.
+func (c *Conn) HealthCheck(ctx context.Context, service string) (HealthStatus, error) {
+	resp, err := healthpb.NewHealthClient(c.raw).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if status.Code(err) == codes.NotFound {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, nil
+	}
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, err
+	}
+	return resp.GetStatus(), nil
+}
+
+// WatchHealth calls fn with every serving status of the service sent by the server,
+// until fn returns false, the server ends the stream or the context is done.
+func (c *Conn) WatchHealth(ctx context.Context, service string, fn func(HealthStatus) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := healthpb.NewHealthClient(c.raw).Watch(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(resp.GetStatus()) {
+			return nil
+		}
+	}
+}
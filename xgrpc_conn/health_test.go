@@ -0,0 +1,82 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(server, hs)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	raw, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(HealthServiceConfig(true, "")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &Conn{raw: raw}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx := context.Background()
+	for service, expected := range map[string]HealthStatus{
+		"":        healthpb.HealthCheckResponse_SERVING,
+		"svc":     healthpb.HealthCheckResponse_NOT_SERVING,
+		"unknown": healthpb.HealthCheckResponse_SERVICE_UNKNOWN,
+	} {
+		got, err := conn.HealthCheck(ctx, service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("unexpected status %s of %q, expected %s", got, service, expected)
+		}
+	}
+
+	var statuses []HealthStatus
+	err = conn.WatchHealth(ctx, "svc", func(s HealthStatus) bool {
+		statuses = append(statuses, s)
+		if s == healthpb.HealthCheckResponse_NOT_SERVING {
+			hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+		}
+		return s != healthpb.HealthCheckResponse_SERVING
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[1] != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+}
+
+func TestHealthServiceConfig(t *testing.T) {
+	t.Parallel()
+
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(HealthServiceConfig(true, `svc"1`)), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config["healthCheckConfig"].(map[string]interface{})["serviceName"] != `svc"1` {
+		t.Fatalf("unexpected config %v", config)
+	}
+	if HealthServiceConfig(false, "svc") != `{"loadBalancingPolicy":"round_robin"}` {
+		t.Fatal("the health checking should be disabled")
+	}
+}
@@ -0,0 +1,117 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstats "google.golang.org/grpc/stats"
+)
+
+// DialFunc dials the network connections of a gRPC connection.
+type DialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// MetricsSink receives the stats of the RPCs of a connection, e.g. to record their metrics.
+type MetricsSink interface {
+	HandleRPC(ctx context.Context, stat grpcstats.RPCStats)
+}
+
+// Options are the options of a connection, they don't depend on k6.
+type Options struct {
+	// TLSConfig is the TLS configuration, the connection is in plaintext when nil.
+	TLSConfig *tls.Config
+	// Dialer dials the network connections, grpc-go's dialer when nil.
+	Dialer DialFunc
+	// Proxy tunnels the connections through the proxies, see ProxyDialer.
+	Proxy ProxyConfig
+	// Sink receives the stats of the RPCs, when set.
+	Sink MetricsSink
+	// Lazy creates the connection without connecting, it is established by the first request.
+	// Otherwise Connect blocks until the connection is established.
+	Lazy      bool
+	UserAgent string
+	// ServiceConfig is the default service config, the round robin balancing when empty.
+	ServiceConfig  string
+	MaxReceiveSize int
+	MaxSendSize    int
+	// PerRPCCredentials authenticate every RPC of the connection, when set.
+	PerRPCCredentials credentials.PerRPCCredentials
+	// DialOptions are appended to the options built from the other fields.
+	DialOptions []grpc.DialOption
+}
+
+// dialOptions returns the gRPC options of the connection, the errors of the dialer are recorded
+// in dialErr when it is set.
+func (o Options) dialOptions(dialErr *lastError) []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithStatsHandler(statsHandler{sink: o.Sink})}
+	dialer := o.Dialer
+	if o.Proxy.Lookup != nil {
+		dialer = ProxyDialer(o.Proxy, dialer)
+	}
+	if dialer != nil {
+		if dialErr != nil {
+			dialer = dialErr.dialer(dialer)
+		}
+		opts = append(opts, grpc.WithContextDialer(dialer))
+	}
+	if o.TLSConfig != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(o.TLSConfig)))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if o.PerRPCCredentials != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(o.PerRPCCredentials))
+	}
+	if o.UserAgent != "" {
+		opts = append(opts, grpc.WithUserAgent(o.UserAgent))
+	}
+	if o.MaxReceiveSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(o.MaxReceiveSize)))
+	}
+	if o.MaxSendSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(o.MaxSendSize)))
+	}
+	serviceConfig := o.ServiceConfig
+	if serviceConfig == "" {
+		serviceConfig = HealthServiceConfig(false, "")
+	}
+	opts = append(opts, grpc.WithDefaultServiceConfig(serviceConfig))
+	return append(opts, o.DialOptions...)
+}
+
+// Connect returns a connection to addr, ctx bounds the dial of a connection which isn't lazy.
+func Connect(ctx context.Context, addr string, o Options) (*Conn, error) {
+	if o.Lazy {
+		return NewClient(addr, o.dialOptions(nil)...)
+	}
+	dialErr := &lastError{}
+	return dial(ctx, addr, dialErr.get, o.dialOptions(dialErr)...)
+}
+
+// lastError records the last error of a dialer.
+type lastError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (l *lastError) dialer(dial DialFunc) DialFunc {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, addr)
+		if err != nil {
+			l.mu.Lock()
+			l.err = err
+			l.mu.Unlock()
+		}
+		return conn, err
+	}
+}
+
+func (l *lastError) get() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
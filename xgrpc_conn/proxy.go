@@ -0,0 +1,283 @@
+package xgrpc_conn
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib/netext"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyFunc returns the proxy of a target address, nil to connect directly.
+type ProxyFunc func(addr string) (*url.URL, error)
+
+// FixedProxy returns the ProxyFunc using the proxy for all the targets.
+func FixedProxy(proxyURL *url.URL) ProxyFunc {
+	return func(string) (*url.URL, error) {
+		return proxyURL, nil
+	}
+}
+
+// ProxyFromEnvironment returns the ProxyFunc of the HTTPS_PROXY and NO_PROXY environment
+// variables, or their lowercase versions, as grpc-go does without a custom dialer.
+// The environment is read once, when it is called.
+func ProxyFromEnvironment() ProxyFunc {
+	lookup := httpproxy.FromEnvironment().ProxyFunc()
+	return func(addr string) (*url.URL, error) {
+		return lookup(&url.URL{Scheme: "https", Host: addr})
+	}
+}
+
+// ProxyConfig are the settings of the proxies tunneling the connections.
+type ProxyConfig struct {
+	// Lookup returns the proxy of a target, there is none when nil.
+	Lookup ProxyFunc
+	// TLSConfig is the TLS configuration of the https proxies, a default one when nil.
+	TLSConfig *tls.Config
+	// CheckTarget checks a target before it is tunneled, e.g. VUTargetCheck,
+	// it returns the address to connect to through the proxy.
+	CheckTarget func(addr string) (string, error)
+}
+
+// ProxyDialer returns the dialer tunneling the connections through the proxies of the config, with
+// HTTP CONNECT for the http and https proxies, or SOCKS5 for the socks5 and socks5h ones.
+// The proxy, or the target when there is none, is dialed with dial, net.Dialer when nil.
+// The unix sockets are never proxied.
+func ProxyDialer(config ProxyConfig, dial DialFunc) DialFunc {
+	if dial == nil {
+		var d net.Dialer
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			if socket, ok := UnixSocket(addr); ok {
+				return d.DialContext(ctx, "unix", socket)
+			}
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		if _, ok := UnixSocket(addr); ok {
+			return dial(ctx, addr)
+		}
+		if config.Lookup == nil {
+			return dial(ctx, addr)
+		}
+		proxyURL, err := config.Lookup(addr)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL == nil {
+			return dial(ctx, addr)
+		}
+		// the dialer only sees the proxy, the target is checked here
+		if config.CheckTarget != nil {
+			if addr, err = config.CheckTarget(addr); err != nil {
+				return nil, err
+			}
+		}
+		switch proxyURL.Scheme {
+		case "http", "https":
+			return dialHTTPConnect(ctx, proxyURL, config.TLSConfig, addr, dial)
+		case "socks5", "socks5h":
+			return dialSOCKS5(ctx, proxyURL, addr, dial)
+		}
+		return nil, fmt.Errorf("unsupported proxy scheme %q, it needs to be http, https, socks5 or socks5h", proxyURL.Scheme)
+	}
+}
+
+// ParseProxyURL parses a proxy URL, the scheme defaults to http.
+func ParseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		// host:port without a scheme
+		if u, err = url.Parse("http://" + raw); err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", raw, err)
+		}
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, it needs to be http, https, socks5 or socks5h", u.Scheme)
+	}
+	if u.Port() == "" {
+		port := "1080"
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+func dialHTTPConnect(
+	ctx context.Context, proxyURL *url.URL, tlsConfig *tls.Config, addr string, dial DialFunc,
+) (net.Conn, error) {
+	conn, err := dial(ctx, proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = proxyURL.Hostname()
+		// the proxy speaks HTTP/1.1, not the ALPN protocols of the target
+		tlsConfig.NextProtos = nil
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("TLS handshake with the proxy %s failed: %w", proxyURL.Host, err)
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("CONNECT request to the proxy %s failed: %w", proxyURL.Host, err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("CONNECT request to the proxy %s failed: %w", proxyURL.Host, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("the proxy %s refused to connect to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads the bytes received after the CONNECT response first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// contextDialer adapts a DialFunc to the dialers of x/net/proxy.
+type contextDialer DialFunc
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	return d(ctx, addr)
+}
+
+func dialSOCKS5(ctx context.Context, proxyURL *url.URL, addr string, dial DialFunc) (net.Conn, error) {
+	var auth *proxy.Auth
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		auth = &proxy.Auth{User: u.Username(), Password: password}
+	}
+	d, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, contextDialer(dial))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.(proxy.ContextDialer).DialContext(ctx, "tcp", addr) //nolint:forcetypeassert
+	if err != nil {
+		return nil, fmt.Errorf("SOCKS5 proxy %s failed to connect to %s: %w", proxyURL.Host, addr, err)
+	}
+	return conn, nil
+}
+
+// VUTargetCheck returns the check of the targets tunneled through a proxy against the blockHostnames,
+// blacklistIPs and hosts options of the VU, as the VU's dialer does for the addresses it dials.
+// A target name is resolved to check it against the blocked IPs, so it fails when it can't be.
+func VUTargetCheck(vu modules.VU) func(addr string) (string, error) {
+	return func(addr string) (string, error) {
+		d, ok := vu.State().Dialer.(*netext.Dialer)
+		if !ok {
+			return addr, nil
+		}
+		return checkTarget(d, addr)
+	}
+}
+
+func checkTarget(d *netext.Dialer, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil && d.BlockedHostnames != nil {
+		if match, blocked := d.BlockedHostnames.Contains(host); blocked {
+			return "", fmt.Errorf("hostname (%s) is in a blocked pattern (%s)", host, match)
+		}
+	}
+	if d.Hosts != nil {
+		remote := d.Hosts.Match(addr)
+		if remote == nil {
+			if remote = d.Hosts.Match(host); remote != nil && remote.Port == 0 {
+				mapped := *remote
+				if mapped.Port, err = strconv.Atoi(port); err != nil {
+					return "", err
+				}
+				remote = &mapped
+			}
+		}
+		if remote != nil {
+			addr, ip = remote.String(), remote.IP
+		}
+	}
+	if ip == nil && len(d.Blacklist) > 0 {
+		if d.Resolver == nil {
+			return "", fmt.Errorf("can't resolve %s to check it against the blocked IPs", host)
+		}
+		if ip, err = d.Resolver.LookupIP(host); err != nil {
+			return "", fmt.Errorf("can't resolve %s to check it against the blocked IPs: %w", host, err)
+		}
+	}
+	for _, ipnet := range d.Blacklist {
+		if ip != nil && ipnet.Contains(ip) {
+			return "", fmt.Errorf("IP (%s) is in a blacklisted range (%s)", ip, ipnet)
+		}
+	}
+	return addr, nil
+}
+
+// VUProxyConfig returns the config of the proxies of lookup for a VU, the targets are checked
+// with VUTargetCheck and the https proxies use the VU's TLS config.
+func VUProxyConfig(vu modules.VU, lookup ProxyFunc) ProxyConfig {
+	config := ProxyConfig{Lookup: lookup, CheckTarget: VUTargetCheck(vu)}
+	if state := vu.State(); state != nil {
+		config.TLSConfig = state.TLSConfig
+	}
+	return config
+}
@@ -0,0 +1,313 @@
+package xgrpc_conn
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/netext"
+	"go.k6.io/k6/lib/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// listen serves the connections of a local listener with handle.
+func listen(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return lis.Addr().String()
+}
+
+// pipe copies the data between the connections until one of them is closed.
+func pipe(a, b net.Conn) {
+	go func() { _, _ = io.Copy(a, b); _ = a.Close() }()
+	_, _ = io.Copy(b, a)
+	_ = b.Close()
+}
+
+// httpProxy is a minimal HTTP CONNECT proxy requiring the user:pass credentials.
+func httpProxy(t *testing.T, tunnels *atomic.Int32) string {
+	return listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+		if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			_ = conn.Close()
+			return
+		}
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			_ = conn.Close()
+			return
+		}
+		tunnels.Add(1)
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipe(conn, target)
+	})
+}
+
+// socks5Proxy is a minimal SOCKS5 proxy without authentication.
+func socks5Proxy(t *testing.T, tunnels *atomic.Int32) string {
+	return listen(t, func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+		buf := make([]byte, 262)
+		// greeting: version, methods
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte{5, 0})
+		// request: version, connect, reserved, address type
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 {
+			return
+		}
+		var host string
+		switch buf[3] {
+		case 1:
+			if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+				return
+			}
+			host = net.IP(buf[:4]).String()
+		case 3:
+			if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+				return
+			}
+			n := int(buf[0])
+			if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+				return
+			}
+			host = string(buf[:n])
+		default:
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return
+		}
+		port := binary.BigEndian.Uint16(buf[:2])
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		tunnels.Add(1)
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		pipe(conn, target)
+	})
+}
+
+func TestProxyDialer(t *testing.T) {
+	t.Parallel()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	target := lis.Addr().String()
+
+	var httpTunnels, socksTunnels atomic.Int32
+	httpAddr, socksAddr := httpProxy(t, &httpTunnels), socks5Proxy(t, &socksTunnels)
+
+	for _, tc := range []struct {
+		proxy   string
+		tunnels *atomic.Int32
+	}{
+		{"http://user:pass@" + httpAddr, &httpTunnels},
+		{"socks5://" + socksAddr, &socksTunnels},
+	} {
+		proxyURL, err := ParseProxyURL(tc.proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dialed []string
+		conn, err := Connect(context.Background(), target, Options{
+			Dialer: func(ctx context.Context, addr string) (net.Conn, error) {
+				dialed = append(dialed, addr)
+				var d net.Dialer
+				return d.DialContext(ctx, "tcp", addr)
+			},
+			Proxy: ProxyConfig{Lookup: FixedProxy(proxyURL)},
+		})
+		if err != nil {
+			t.Fatal(tc.proxy, err)
+		}
+		if _, err = conn.HealthCheck(context.Background(), ""); err != nil {
+			t.Fatal(tc.proxy, err)
+		}
+		_ = conn.Close()
+		if tc.tunnels.Load() != 1 {
+			t.Errorf("%s: the connection should be tunneled", tc.proxy)
+		}
+		if len(dialed) != 1 || dialed[0] != proxyURL.Host {
+			t.Errorf("%s: the proxy should be dialed with the dialer, got %v", tc.proxy, dialed)
+		}
+	}
+
+	// the proxy refuses the connection without the credentials
+	dial := ProxyDialer(ProxyConfig{Lookup: FixedProxy(&url.URL{Scheme: "http", Host: httpAddr})}, nil)
+	if _, err = dial(context.Background(), target); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("expected the refusal of the proxy, got %v", err)
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	t.Parallel()
+
+	for raw, expected := range map[string]string{
+		"proxy.local:3128":           "http://proxy.local:3128",
+		"10.0.0.1:3128":              "http://10.0.0.1:3128",
+		"http://proxy.local":         "http://proxy.local:80",
+		"https://u:p@proxy.local":    "https://u:p@proxy.local:443",
+		"socks5://proxy.local":       "socks5://proxy.local:1080",
+		"socks5h://proxy.local:9050": "socks5h://proxy.local:9050",
+	} {
+		u, err := ParseProxyURL(raw)
+		if err != nil {
+			t.Fatal(raw, err)
+		}
+		if u.String() != expected {
+			t.Errorf("%s: expected %s, got %s", raw, expected, u)
+		}
+	}
+	if _, err := ParseProxyURL("ftp://proxy.local"); err == nil {
+		t.Error("expected an error for an unsupported scheme")
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://proxy.local:3128")
+	t.Setenv("NO_PROXY", "internal.local")
+
+	proxyFor := ProxyFromEnvironment()
+	u, err := proxyFor("api.example.com:443")
+	if err != nil || u == nil || u.Host != "proxy.local:3128" {
+		t.Fatalf("unexpected proxy %v %v", u, err)
+	}
+	if u, err = proxyFor("svc.internal.local:443"); err != nil || u != nil {
+		t.Fatalf("NO_PROXY should be honored, got %v %v", u, err)
+	}
+}
+
+func TestProxyTargetCheck(t *testing.T) {
+	t.Parallel()
+
+	var tunnels atomic.Int32
+	httpAddr := httpProxy(t, &tunnels)
+	blocked, err := types.NewHostnameTrie([]string{"*.blocked.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipnet, err := lib.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := types.NewHosts(map[string]types.Host{"alias.local": {IP: net.ParseIP("10.1.2.3")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := netext.NewDialer(net.Dialer{}, nil)
+	dialer.BlockedHostnames, dialer.Blacklist, dialer.Hosts = blocked, []*lib.IPNet{ipnet}, hosts
+	vu := &modulestest.VU{StateField: &lib.State{Dialer: dialer}}
+
+	config := VUProxyConfig(vu, FixedProxy(&url.URL{Scheme: "http", Host: httpAddr, User: url.UserPassword("user", "pass")}))
+	dial := ProxyDialer(config, VUDialer(vu))
+	for addr, expected := range map[string]string{
+		"api.blocked.local:443": "blocked pattern",
+		"10.0.0.1:443":          "blacklisted range",
+		"alias.local:443":       "blacklisted range",
+	} {
+		if _, err := dial(context.Background(), addr); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q, got %v", addr, expected, err)
+		}
+	}
+	if tunnels.Load() != 0 {
+		t.Fatal("the blocked targets must not be tunneled")
+	}
+}
+
+func TestHTTPSProxyTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	var tunnels atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		tunnels.Add(1)
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipe(conn, target)
+	}))
+	t.Cleanup(srv.Close)
+	target := listen(t, func(conn net.Conn) { _, _ = io.Copy(conn, conn) })
+
+	proxyURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := ProxyConfig{Lookup: FixedProxy(proxyURL)}
+	if _, err = ProxyDialer(config, nil)(context.Background(), target); err == nil {
+		t.Fatal("the certificate of the proxy should be verified")
+	}
+
+	config.TLSConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+	conn, err := ProxyDialer(config, nil)(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err = io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("unexpected echo %q %v", buf, err)
+	}
+	if tunnels.Load() != 1 {
+		t.Fatal("the connection should be tunneled")
+	}
+}
@@ -2,14 +2,27 @@ package xgrpc_conn
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 
 	"google.golang.org/grpc"
-	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/codes"
+	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectv1alphapb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
 )
 
+// reflectionMethods are the reflection services' methods in order of preference,
+// the messages of the v1alpha version are the same as the v1 ones.
+var reflectionMethods = []string{ //nolint:gochecknoglobals
+	reflectpb.ServerReflection_ServerReflectionInfo_FullMethodName,
+	reflectv1alphapb.ServerReflection_ServerReflectionInfo_FullMethodName,
+}
+
 // ReflectionClient wraps a grpc.ServerReflectionClient.
 type reflectionClient struct {
 	Conn grpc.ClientConnInterface
@@ -17,16 +30,69 @@ type reflectionClient struct {
 
 // Reflect will use the grpc reflection api to make the file descriptors available to request.
 // It is called in the connect function the first time the Client.Connect function is called.
-func (rc *reflectionClient) Reflect(ctx context.Context) (*descriptorpb.FileDescriptorSet, error) {
-	client := reflectpb.NewServerReflectionClient(rc.Conn)
-	methodClient, err := client.ServerReflectionInfo(ctx)
+// Only the given services are resolved, all the services when none is given.
+func (rc *reflectionClient) Reflect(ctx context.Context, services ...string) (*descriptorpb.FileDescriptorSet, error) {
+	var fdset *descriptorpb.FileDescriptorSet
+	err := rc.withStream(ctx, func(methodClient sendReceiver) error {
+		names := services
+		if len(names) == 0 {
+			var err error
+			if names, err = listServices(methodClient); err != nil {
+				return err
+			}
+		}
+		var err error
+		fdset, err = rc.resolveServiceFileDescriptors(methodClient, names)
+		if err != nil {
+			return fmt.Errorf("can't resolve services' file descriptors: %w", err)
+		}
+		return nil
+	})
+	return fdset, err
+}
+
+// ListServices returns the names of the services exposed by the server.
+func (rc *reflectionClient) ListServices(ctx context.Context) ([]string, error) {
+	var names []string
+	err := rc.withStream(ctx, func(methodClient sendReceiver) error {
+		var err error
+		names, err = listServices(methodClient)
+		return err
+	})
+	return names, err
+}
+
+// withStream calls fn with a reflection stream, the v1 api is used
+// or v1alpha when the server doesn't implement it.
+func (rc *reflectionClient) withStream(ctx context.Context, fn func(sendReceiver) error) error {
+	var err error
+	for _, method := range reflectionMethods {
+		err = rc.callStream(ctx, method, fn)
+		if status.Code(err) != codes.Unimplemented {
+			return err
+		}
+	}
+	return err
+}
+
+func (rc *reflectionClient) callStream(ctx context.Context, method string, fn func(sendReceiver) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := rc.Conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, method)
 	if err != nil {
-		return nil, fmt.Errorf("can't get server info: %w", err)
+		return fmt.Errorf("can't get server info: %w", err)
 	}
+	return fn(&grpc.GenericClientStream[
+		reflectpb.ServerReflectionRequest, reflectpb.ServerReflectionResponse,
+	]{ClientStream: stream})
+}
+
+func listServices(client sendReceiver) ([]string, error) {
 	req := &reflectpb.ServerReflectionRequest{
 		MessageRequest: &reflectpb.ServerReflectionRequest_ListServices{},
 	}
-	resp, err := sendReceive(methodClient, req)
+	resp, err := sendReceive(client, req)
 	if err != nil {
 		return nil, fmt.Errorf("can't list services: %w", err)
 	}
@@ -34,18 +100,17 @@ func (rc *reflectionClient) Reflect(ctx context.Context) (*descriptorpb.FileDesc
 	if listResp == nil {
 		return nil, fmt.Errorf("can't list services, nil response")
 	}
-	fdset, err := rc.resolveServiceFileDescriptors(methodClient, listResp)
-	if err != nil {
-		return nil, fmt.Errorf("can't resolve services' file descriptors: %w", err)
+	names := make([]string, 0, len(listResp.GetService()))
+	for _, service := range listResp.GetService() {
+		names = append(names, service.GetName())
 	}
-	return fdset, nil
+	return names, nil
 }
 
 func (rc *reflectionClient) resolveServiceFileDescriptors(
 	client sendReceiver,
-	res *reflectpb.ListServiceResponse,
+	services []string,
 ) (*descriptorpb.FileDescriptorSet, error) {
-	services := res.GetService()
 	seen := make(map[fileDescriptorLookupKey]bool, len(services))
 	fdset := &descriptorpb.FileDescriptorSet{
 		File: make([]*descriptorpb.FileDescriptorProto, 0, len(services)),
@@ -54,22 +119,17 @@ func (rc *reflectionClient) resolveServiceFileDescriptors(
 	for _, service := range services {
 		req := &reflectpb.ServerReflectionRequest{
 			MessageRequest: &reflectpb.ServerReflectionRequest_FileContainingSymbol{
-				FileContainingSymbol: service.GetName(),
+				FileContainingSymbol: service,
 			},
 		}
-		resp, err := sendReceive(client, req)
+		fdps, err := requestFileDescriptors(client, req)
 		if err != nil {
 			return nil, fmt.Errorf("can't get method on service %q: %w", service, err)
 		}
-		fdResp := resp.GetFileDescriptorResponse()
-		for _, raw := range fdResp.GetFileDescriptorProto() {
-			var fdp descriptorpb.FileDescriptorProto
-			if err = proto.Unmarshal(raw, &fdp); err != nil {
-				return nil, fmt.Errorf("can't unmarshal proto on service %q: %w", service, err)
-			}
+		for _, fdp := range fdps {
 			fdkey := fileDescriptorLookupKey{
-				Package: *fdp.Package,
-				Name:    *fdp.Name,
+				Package: fdp.GetPackage(),
+				Name:    fdp.GetName(),
 			}
 			if seen[fdkey] {
 				// When a proto file contains declarations for multiple services
@@ -78,12 +138,82 @@ func (rc *reflectionClient) resolveServiceFileDescriptors(
 				continue
 			}
 			seen[fdkey] = true
-			fdset.File = append(fdset.File, &fdp)
+			fdset.File = append(fdset.File, fdp)
 		}
 	}
+
+	if err := resolveDependencies(client, fdset); err != nil {
+		return nil, err
+	}
 	return fdset, nil
 }
 
+// resolveDependencies requests the imports missing from the set until it is closed,
+// some servers only return the file containing the symbol and not its dependencies.
+// An import the server doesn't know is accepted when it is linked into the binary.
+func resolveDependencies(client sendReceiver, fdset *descriptorpb.FileDescriptorSet) error {
+	names := make(map[string]bool, len(fdset.GetFile()))
+	for _, fdp := range fdset.GetFile() {
+		names[fdp.GetName()] = true
+	}
+	requested := make(map[string]bool)
+
+	// the set grows while iterating, the returned files are checked in turn
+	for i := 0; i < len(fdset.File); i++ {
+		for _, dep := range fdset.File[i].GetDependency() {
+			if names[dep] || requested[dep] {
+				continue
+			}
+			requested[dep] = true
+
+			req := &reflectpb.ServerReflectionRequest{
+				MessageRequest: &reflectpb.ServerReflectionRequest_FileByFilename{
+					FileByFilename: dep,
+				},
+			}
+			fdps, err := requestFileDescriptors(client, req)
+			if err != nil {
+				if _, linkedErr := protoregistry.GlobalFiles.FindFileByPath(dep); linkedErr == nil {
+					continue
+				}
+				return fmt.Errorf("can't get the file %q: %w", dep, err)
+			}
+			for _, fdp := range fdps {
+				if !names[fdp.GetName()] {
+					names[fdp.GetName()] = true
+					fdset.File = append(fdset.File, fdp)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// requestFileDescriptors sends a request answered with file descriptors and decodes them.
+func requestFileDescriptors(
+	client sendReceiver,
+	req *reflectpb.ServerReflectionRequest,
+) ([]*descriptorpb.FileDescriptorProto, error) {
+	resp, err := sendReceive(client, req)
+	if err != nil {
+		return nil, err
+	}
+	if errResp := resp.GetErrorResponse(); errResp != nil {
+		return nil, status.Error(codes.Code(errResp.GetErrorCode()), errResp.GetErrorMessage())
+	}
+
+	raws := resp.GetFileDescriptorResponse().GetFileDescriptorProto()
+	fdps := make([]*descriptorpb.FileDescriptorProto, 0, len(raws))
+	for _, raw := range raws {
+		fdp := &descriptorpb.FileDescriptorProto{}
+		if err = proto.Unmarshal(raw, fdp); err != nil {
+			return nil, fmt.Errorf("can't unmarshal proto: %w", err)
+		}
+		fdps = append(fdps, fdp)
+	}
+	return fdps, nil
+}
+
 // sendReceiver is a smaller interface for decoupling
 // from `reflectpb.ServerReflection_ServerReflectionInfoClient`,
 // that has the dependency from `grpc.ClientStream`,
@@ -101,7 +231,8 @@ func sendReceive(
 	client sendReceiver,
 	req *reflectpb.ServerReflectionRequest,
 ) (*reflectpb.ServerReflectionResponse, error) {
-	if err := client.Send(req); err != nil {
+	// on io.EOF the stream is closed, the status is returned by Recv
+	if err := client.Send(req); err != nil && !errors.Is(err, io.EOF) {
 		return nil, fmt.Errorf("can't send request: %w", err)
 	}
 	resp, err := client.Recv()
@@ -0,0 +1,135 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectv1alphagrpc "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// fileServer answers the reflection requests with a single file, as some servers do.
+type fileServer struct {
+	files map[string]*descriptorpb.FileDescriptorProto
+	byRef map[string]string
+	resp  *reflectpb.ServerReflectionResponse
+}
+
+func (s *fileServer) Send(req *reflectpb.ServerReflectionRequest) error {
+	name := req.GetFileByFilename()
+	if symbol := req.GetFileContainingSymbol(); symbol != "" {
+		name = s.byRef[symbol]
+	}
+	fdp, ok := s.files[name]
+	if !ok {
+		s.resp = &reflectpb.ServerReflectionResponse{
+			MessageResponse: &reflectpb.ServerReflectionResponse_ErrorResponse{
+				ErrorResponse: &reflectpb.ErrorResponse{ErrorCode: int32(codes.NotFound), ErrorMessage: "not found"},
+			},
+		}
+		return nil
+	}
+	raw, err := proto.Marshal(fdp)
+	if err != nil {
+		return err
+	}
+	s.resp = &reflectpb.ServerReflectionResponse{
+		MessageResponse: &reflectpb.ServerReflectionResponse_FileDescriptorResponse{
+			FileDescriptorResponse: &reflectpb.FileDescriptorResponse{FileDescriptorProto: [][]byte{raw}},
+		},
+	}
+	return nil
+}
+
+func (s *fileServer) Recv() (*reflectpb.ServerReflectionResponse, error) {
+	return s.resp, nil
+}
+
+func TestReflectResolveDependencies(t *testing.T) {
+	t.Parallel()
+
+	fdset := parseFileSet(t, map[string]string{
+		"base.proto": `syntax = "proto3"; package base; message Base { string id = 1; }`,
+		"common.proto": `syntax = "proto3"; package common; import "base.proto"; import "google/protobuf/empty.proto";
+			message Item { base.Base base = 1; google.protobuf.Empty empty = 2; }`,
+		"svc.proto": `syntax = "proto3"; package svc; import "common.proto";
+			service S { rpc Get(common.Item) returns (common.Item); }`,
+	}, "base.proto", "common.proto", "svc.proto")
+	server := &fileServer{
+		files: make(map[string]*descriptorpb.FileDescriptorProto),
+		byRef: map[string]string{"svc.S": "svc.proto"},
+	}
+	for _, fdp := range fdset.GetFile() {
+		server.files[fdp.GetName()] = fdp
+	}
+
+	rc := reflectionClient{}
+	list := []string{"svc.S"}
+	got, err := rc.resolveServiceFileDescriptors(server, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fdp := range got.GetFile() {
+		names = append(names, fdp.GetName())
+	}
+	if len(names) != 3 || names[0] != "svc.proto" || names[1] != "common.proto" || names[2] != "base.proto" {
+		t.Fatalf("unexpected files %v", names)
+	}
+	if _, err = NewRegistry().Register(got); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(server.files, "base.proto")
+	if _, err = rc.resolveServiceFileDescriptors(server, list); err == nil {
+		t.Fatal("expected an error for the missing import")
+	}
+}
+
+func TestReflectV1AlphaFallback(t *testing.T) {
+	t.Parallel()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	// only the v1alpha version, as the older servers
+	reflectv1alphagrpc.RegisterServerReflectionServer(server, reflection.NewServer(reflection.ServerOptions{Services: server}))
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	rc := reflectionClient{Conn: conn}
+	fdset, err := rc.Reflect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fdset.GetFile()) == 0 || fdset.GetFile()[0].GetPackage() != "grpc.reflection.v1alpha" {
+		t.Fatalf("unexpected files %v", fdset.GetFile())
+	}
+
+	services, err := rc.ListServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0] != "grpc.reflection.v1alpha.ServerReflection" {
+		t.Fatalf("unexpected services %v", services)
+	}
+	if _, err = rc.Reflect(context.Background(), "unknown.Service"); err == nil {
+		t.Fatal("expected an error for an unknown service")
+	}
+}
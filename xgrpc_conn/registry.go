@@ -0,0 +1,207 @@
+package xgrpc_conn
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// TypeResolver resolves the message and extension types, e.g. the content of a google.protobuf.Any.
+type TypeResolver interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+}
+
+// Registry holds the file descriptors loaded by a client and resolves their types.
+// The types linked into the binary, such as the well-known types and the google.rpc
+// error details, are resolved when they aren't part of the loaded files.
+type Registry struct {
+	files *protoregistry.Files
+	types *dynamicpb.Types
+}
+
+var _ TypeResolver = &Registry{}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	files := &protoregistry.Files{}
+	return &Registry{
+		files: files,
+		types: dynamicpb.NewTypes(files),
+	}
+}
+
+// Files returns the registered file descriptors.
+func (r *Registry) Files() *protoregistry.Files {
+	return r.files
+}
+
+// Register adds the files of the set to the registry and returns them. The imports are
+// resolved with the files of the set, the files already registered and the files linked
+// into the binary, so a set can import the files of a previously registered one.
+// A file already registered with the same name is not registered again, it is an error
+// when its content differs.
+func (r *Registry) Register(fdset *descriptorpb.FileDescriptorSet) ([]protoreflect.FileDescriptor, error) {
+	pending := make(map[string]*descriptorpb.FileDescriptorProto, len(fdset.GetFile()))
+	for _, fdp := range fdset.GetFile() {
+		pending[fdp.GetName()] = fdp
+	}
+
+	result := make([]protoreflect.FileDescriptor, 0, len(pending))
+	var register func(name string, importedBy []string) error
+	register = func(name string, importedBy []string) error {
+		fdp, ok := pending[name]
+		if !ok {
+			return nil
+		}
+		for _, n := range importedBy {
+			if n == name {
+				return fmt.Errorf("import cycle in %q", name)
+			}
+		}
+		for _, dep := range fdp.GetDependency() {
+			if err := register(dep, append(importedBy, name)); err != nil {
+				return err
+			}
+		}
+		delete(pending, name)
+
+		if fd, err := r.files.FindFileByPath(name); err == nil {
+			if !sameFile(fd, fdp) {
+				return fmt.Errorf("%w: %s", ErrFileConflict, name)
+			}
+			result = append(result, fd)
+			return nil
+		}
+		fd, err := protodesc.NewFile(fdp, r)
+		if err != nil {
+			return err
+		}
+		if err = r.files.RegisterFile(fd); err != nil {
+			return err
+		}
+		result = append(result, fd)
+		return nil
+	}
+
+	for _, fdp := range fdset.GetFile() {
+		if err := register(fdp.GetName(), nil); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// RegisterFiles adds already built file descriptors, e.g. shared with other registries.
+// The files must be ordered with their imports first, a file already registered
+// with the same name is not registered again, it is an error when its content differs.
+func (r *Registry) RegisterFiles(files []protoreflect.FileDescriptor) error {
+	for _, fd := range files {
+		if registered, err := r.files.FindFileByPath(fd.Path()); err == nil {
+			if registered != fd && !sameFile(registered, protodesc.ToFileDescriptorProto(fd)) {
+				return fmt.Errorf("%w: %s", ErrFileConflict, fd.Path())
+			}
+			continue
+		}
+		if err := r.files.RegisterFile(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrFileConflict is returned when a file is registered with another content under the same name.
+var ErrFileConflict = errors.New("a different file is already registered with the same name")
+
+// sameFile reports whether the registered file has the content of fdp. The source info
+// and the JSON names, set by some compilers only, are ignored.
+func sameFile(fd protoreflect.FileDescriptor, fdp *descriptorpb.FileDescriptorProto) bool {
+	return proto.Equal(normalizedFile(protodesc.ToFileDescriptorProto(fd)), normalizedFile(fdp))
+}
+
+func normalizedFile(fdp *descriptorpb.FileDescriptorProto) *descriptorpb.FileDescriptorProto {
+	fdp = proto.Clone(fdp).(*descriptorpb.FileDescriptorProto) //nolint:forcetypeassert
+	fdp.SourceCodeInfo = nil
+	clearJSONNames(fdp.GetExtension())
+	for _, m := range fdp.GetMessageType() {
+		normalizeMessage(m)
+	}
+	return fdp
+}
+
+func normalizeMessage(m *descriptorpb.DescriptorProto) {
+	clearJSONNames(m.GetField())
+	clearJSONNames(m.GetExtension())
+	for _, nested := range m.GetNestedType() {
+		normalizeMessage(nested)
+	}
+}
+
+func clearJSONNames(fields []*descriptorpb.FieldDescriptorProto) {
+	for _, f := range fields {
+		f.JsonName = nil
+	}
+}
+
+// FindFileByPath implements the protodesc.Resolver interface.
+func (r *Registry) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	fd, err := r.files.FindFileByPath(path)
+	if errors.Is(err, protoregistry.NotFound) {
+		return protoregistry.GlobalFiles.FindFileByPath(path)
+	}
+	return fd, err
+}
+
+// FindDescriptorByName implements the protodesc.Resolver interface.
+func (r *Registry) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	d, err := r.files.FindDescriptorByName(name)
+	if errors.Is(err, protoregistry.NotFound) {
+		return protoregistry.GlobalFiles.FindDescriptorByName(name)
+	}
+	return d, err
+}
+
+// FindMessageByName implements the protoregistry.MessageTypeResolver interface.
+func (r *Registry) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	mt, err := r.types.FindMessageByName(name)
+	if errors.Is(err, protoregistry.NotFound) {
+		return protoregistry.GlobalTypes.FindMessageByName(name)
+	}
+	return mt, err
+}
+
+// FindMessageByURL implements the protoregistry.MessageTypeResolver interface.
+func (r *Registry) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	mt, err := r.types.FindMessageByURL(url)
+	if errors.Is(err, protoregistry.NotFound) {
+		return protoregistry.GlobalTypes.FindMessageByURL(url)
+	}
+	return mt, err
+}
+
+// FindExtensionByName implements the protoregistry.ExtensionTypeResolver interface.
+func (r *Registry) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	xt, err := r.types.FindExtensionByName(field)
+	if errors.Is(err, protoregistry.NotFound) {
+		return protoregistry.GlobalTypes.FindExtensionByName(field)
+	}
+	return xt, err
+}
+
+// FindExtensionByNumber implements the protoregistry.ExtensionTypeResolver interface.
+func (r *Registry) FindExtensionByNumber(
+	message protoreflect.FullName,
+	field protoreflect.FieldNumber,
+) (protoreflect.ExtensionType, error) {
+	xt, err := r.types.FindExtensionByNumber(message, field)
+	if errors.Is(err, protoregistry.NotFound) {
+		return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+	}
+	return xt, err
+}
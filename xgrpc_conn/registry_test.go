@@ -0,0 +1,85 @@
+package xgrpc_conn
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// parseFileSet parses the proto sources and returns the set of the given files.
+func parseFileSet(t *testing.T, sources map[string]string, names ...string) *descriptorpb.FileDescriptorSet {
+	t.Helper()
+	parser := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(sources)}
+	fds, err := parser.ParseFiles(names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdset := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range fds {
+		fdset.File = append(fdset.File, protodesc.ToFileDescriptorProto(fd.UnwrapFile()))
+	}
+	return fdset
+}
+
+func TestRegistry(t *testing.T) {
+	v1 := map[string]string{"common.proto": `syntax = "proto3"; package common; message Item { string id = 1; }`}
+	v2 := map[string]string{"common.proto": `syntax = "proto3"; package common; message Item { int64 id = 1; }`}
+	svc := map[string]string{
+		"common.proto": v1["common.proto"],
+		"svc.proto": `syntax = "proto3"; package svc; import "common.proto";
+			service S { rpc Get(common.Item) returns (common.Item); }`,
+	}
+
+	first, second := NewRegistry(), NewRegistry()
+	if _, err := first.Register(parseFileSet(t, v1, "common.proto")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Register(parseFileSet(t, v2, "common.proto")); err != nil {
+		t.Fatal(err)
+	}
+	for reg, kind := range map[*Registry]string{first: "string", second: "int64"} {
+		mt, err := reg.FindMessageByURL("type.googleapis.com/common.Item")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := mt.Descriptor().Fields().ByName("id").Kind().String(); got != kind {
+			t.Errorf("expected the registry's own definition %s, got %s", kind, got)
+		}
+	}
+
+	// the second set only holds svc.proto, its import comes from the first set
+	fdset := parseFileSet(t, svc, "svc.proto")
+	fdset.File = fdset.File[len(fdset.File)-1:]
+	files, err := first.Register(fdset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Services().Len() != 1 {
+		t.Fatalf("unexpected registered files %v", files)
+	}
+
+	if _, err = first.FindMessageByName("google.rpc.ErrorInfo"); err != nil {
+		t.Errorf("the linked types must be resolved, got %v", err)
+	}
+
+	// a file registered again must have the same content
+	same := parseFileSet(t, v1, "common.proto")
+	same.File[0].SourceCodeInfo = nil
+	if _, err = first.Register(same); err != nil {
+		t.Fatalf("the same file should be reused, got %v", err)
+	}
+	if _, err = first.Register(parseFileSet(t, v2, "common.proto")); !errors.Is(err, ErrFileConflict) {
+		t.Fatalf("expected ErrFileConflict, got %v", err)
+	}
+	other, err := second.FindFileByPath("common.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = first.RegisterFiles([]protoreflect.FileDescriptor{other}); !errors.Is(err, ErrFileConflict) {
+		t.Fatalf("expected ErrFileConflict, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package xgrpc_conn
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // some signed APIs still use HMAC-SHA1
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// The parts of the canonical string of a signed request.
+const (
+	SigningPartMethod    = "method"
+	SigningPartTimestamp = "timestamp"
+	SigningPartBody      = "body"
+	SigningPartAPIKey    = "apiKey"
+)
+
+// Signer signs the requests with an HMAC of a canonical string built from the method,
+// the timestamp and the serialized request, the signature, the timestamp and the API key
+// are sent as metadata. Zero fields take their default value.
+type Signer struct {
+	// Algorithm is the hash of the HMAC: sha256 (default), sha1 or sha512.
+	Algorithm string
+	Secret    []byte
+	// APIKey is sent with the APIKeyKey metadata key, when set.
+	APIKey string
+	// Layout lists the parts of the canonical string, method, timestamp and body by default.
+	Layout []string
+	// Separator joins the parts, they are concatenated when empty.
+	Separator string
+	// Body is how the request is signed: proto, the bytes sent (default), or json.
+	Body string
+	// Encoding is the encoding of the signature: hex (default) or base64.
+	Encoding string
+	// TimestampFormat is unix (seconds, default), unixms or rfc3339.
+	TimestampFormat string
+	// The metadata keys, x-sign, x-timestamp and x-api-key by default.
+	SignatureKey string
+	TimestampKey string
+	APIKeyKey    string
+	// Now returns the time of the requests, time.Now when nil.
+	Now func() time.Time
+}
+
+// Validate checks the settings of the signer.
+func (s *Signer) Validate() error {
+	if len(s.Secret) == 0 {
+		return fmt.Errorf("the signing secret is required")
+	}
+	if _, err := s.hash(); err != nil {
+		return err
+	}
+	for _, part := range s.Layout {
+		switch part {
+		case SigningPartMethod, SigningPartTimestamp, SigningPartBody, SigningPartAPIKey:
+		default:
+			return fmt.Errorf("unknown signing layout part %q, it needs to be method, timestamp, body or apiKey", part)
+		}
+	}
+	switch s.Body {
+	case "", "proto", "json":
+	default:
+		return fmt.Errorf("unknown signing body %q, it needs to be proto or json", s.Body)
+	}
+	switch s.Encoding {
+	case "", "hex", "base64":
+	default:
+		return fmt.Errorf("unknown signature encoding %q, it needs to be hex or base64", s.Encoding)
+	}
+	switch s.TimestampFormat {
+	case "", "unix", "unixms", "rfc3339":
+	default:
+		return fmt.Errorf("unknown timestamp format %q, it needs to be unix, unixms or rfc3339", s.TimestampFormat)
+	}
+	return nil
+}
+
+func (s *Signer) hash() (func() hash.Hash, error) {
+	switch strings.ToLower(s.Algorithm) {
+	case "", "sha256", "hmac-sha256":
+		return sha256.New, nil
+	case "sha1", "hmac-sha1":
+		return sha1.New, nil
+	case "sha512", "hmac-sha512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q, it needs to be sha256, sha1 or sha512", s.Algorithm)
+}
+
+func (s *Signer) timestamp() string {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now()
+	switch s.TimestampFormat {
+	case "unixms":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case "rfc3339":
+		return t.UTC().Format(time.RFC3339)
+	}
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// Canonical returns the canonical string of a request, body is in the format of the Body field.
+func (s *Signer) Canonical(method, timestamp string, body []byte) []byte {
+	layout := s.Layout
+	if len(layout) == 0 {
+		layout = []string{SigningPartMethod, SigningPartTimestamp, SigningPartBody}
+	}
+	var buf bytes.Buffer
+	for i, part := range layout {
+		if i > 0 {
+			buf.WriteString(s.Separator)
+		}
+		switch part {
+		case SigningPartMethod:
+			buf.WriteString(method)
+		case SigningPartTimestamp:
+			buf.WriteString(timestamp)
+		case SigningPartBody:
+			buf.Write(body)
+		case SigningPartAPIKey:
+			buf.WriteString(s.APIKey)
+		}
+	}
+	return buf.Bytes()
+}
+
+// Sign adds the signature of the request to md, body is in the format of the Body field.
+func (s *Signer) Sign(method string, body []byte, md metadata.MD) error {
+	newHash, err := s.hash()
+	if err != nil {
+		return err
+	}
+	timestamp := s.timestamp()
+	mac := hmac.New(newHash, s.Secret)
+	mac.Write(s.Canonical(method, timestamp, body))
+	sum := mac.Sum(nil)
+
+	signature := hex.EncodeToString(sum)
+	if s.Encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(sum)
+	}
+	md.Set(keyOrDefault(s.SignatureKey, "x-sign"), signature)
+	md.Set(keyOrDefault(s.TimestampKey, "x-timestamp"), timestamp)
+	if s.APIKey != "" {
+		md.Set(keyOrDefault(s.APIKeyKey, "x-api-key"), s.APIKey)
+	}
+	return nil
+}
+
+func keyOrDefault(key, def string) string {
+	if key == "" {
+		return def
+	}
+	return key
+}
+
+// signRequest serializes the request message and signs it, the returned message
+// is sent verbatim so the signature matches the bytes on the wire.
+func (req Request) signRequest(method string, reqmsg interface{}, md metadata.MD) (rawMessage, error) {
+	var wire []byte
+	switch msg := reqmsg.(type) {
+	case rawMessage:
+		wire = msg
+	case proto.Message:
+		var err error
+		if wire, err = proto.Marshal(msg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("can't sign a %T request", reqmsg)
+	}
+
+	body := wire
+	if req.Signer.Body == "json" {
+		msg := dynamicpb.NewMessage(req.MethodDescriptor.Input())
+		resolver := req.resolver()
+		if err := (proto.UnmarshalOptions{Resolver: resolver}).Unmarshal(wire, msg); err != nil {
+			return nil, err
+		}
+		b, err := req.jsonOptions().marshalOptions(resolver).Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		// protojson randomizes its whitespaces, the compact form is stable
+		var compact bytes.Buffer
+		if err = json.Compact(&compact, b); err != nil {
+			return nil, err
+		}
+		body = compact.Bytes()
+	}
+	if err := req.Signer.Sign(method, body, md); err != nil {
+		return nil, err
+	}
+	return wire, nil
+}
@@ -0,0 +1,171 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// capturingCodec records the request bytes received by the server.
+type capturingCodec struct {
+	rawCodec
+	mu   sync.Mutex
+	last []byte
+}
+
+func (c *capturingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.mu.Lock()
+	c.last = append([]byte(nil), data...)
+	c.mu.Unlock()
+	return c.rawCodec.Unmarshal(data, v)
+}
+
+func (c *capturingCodec) received() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+func TestSignedInvoke(t *testing.T) {
+	t.Parallel()
+
+	codec := &capturingCodec{}
+	incoming := make(chan metadata.MD, 1)
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(codec),
+		grpc.UnaryInterceptor(
+			func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				md, _ := metadata.FromIncomingContext(ctx)
+				incoming <- md
+				return handler(ctx, req)
+			}),
+	)
+	hs := health.NewServer()
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, hs)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	raw, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &Conn{raw: raw}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	const method = "/grpc.health.v1.Health/Check"
+	md := healthpb.File_grpc_health_v1_health_proto.Services().ByName("Health").Methods().ByName("Check")
+	now := time.Unix(1700000000, 0)
+	sign := func(canonical string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(canonical))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	for _, body := range []string{"proto", "json"} {
+		signer := &Signer{
+			Secret:    []byte("secret"),
+			APIKey:    "key-1",
+			Layout:    []string{SigningPartMethod, SigningPartTimestamp, SigningPartBody, SigningPartAPIKey},
+			Separator: "\n",
+			Body:      body,
+			Now:       func() time.Time { return now },
+		}
+		if err = signer.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		callMD := metadata.Pairs("x-trace", "1")
+		resp, err := conn.InvokeRequest(context.Background(), method, callMD, Request{
+			MethodDescriptor: md,
+			Message:          []byte(`{"service": "svc"}`),
+			Signer:           signer,
+		})
+		if err != nil {
+			t.Fatal(body, err)
+		}
+		if resp.Error != nil {
+			t.Fatalf("%s: unexpected error %v", body, resp.Error)
+		}
+		if len(callMD.Get("x-sign")) > 0 {
+			t.Fatalf("%s: the metadata of the caller must not be modified", body)
+		}
+
+		got := <-incoming
+		signed := string(codec.received())
+		if body == "json" {
+			signed = `{"service":"svc"}`
+		}
+		expected := sign(method + "\n1700000000\n" + signed + "\nkey-1")
+		if s := got.Get("x-sign"); len(s) != 1 || s[0] != expected {
+			t.Errorf("%s: unexpected signature %v, expected %s", body, s, expected)
+		}
+		if ts := got.Get("x-timestamp"); len(ts) != 1 || ts[0] != "1700000000" {
+			t.Errorf("%s: unexpected timestamp %v", body, ts)
+		}
+		if key := got.Get("x-api-key"); len(key) != 1 || key[0] != "key-1" {
+			t.Errorf("%s: unexpected api key %v", body, key)
+		}
+		if len(got.Get("x-trace")) != 1 {
+			t.Errorf("%s: the metadata of the call should be kept", body)
+		}
+	}
+
+	// the signed bytes are protobuf, another codec can't send them
+	_, err = conn.InvokeRequest(context.Background(), method, nil, Request{
+		MethodDescriptor: md,
+		Message:          []byte(`{"service": "svc"}`),
+		Signer:           &Signer{Secret: []byte("secret")},
+	}, grpc.CallContentSubtype("json"))
+	if err == nil {
+		t.Fatal("expected an error for a signed request with another content subtype")
+	}
+}
+
+func TestSignerValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []Signer{
+		{},
+		{Secret: []byte("s"), Algorithm: "md5"},
+		{Secret: []byte("s"), Layout: []string{"path"}},
+		{Secret: []byte("s"), Body: "text"},
+		{Secret: []byte("s"), Encoding: "base32"},
+		{Secret: []byte("s"), TimestampFormat: "iso"},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", s)
+		}
+	}
+
+	s := Signer{Secret: []byte("s"), Encoding: "base64", TimestampFormat: "unixms", SignatureKey: "sig",
+		Now: func() time.Time { return time.UnixMilli(1700000000123) }}
+	md := metadata.MD{}
+	if err := s.Sign("/a.B/C", []byte("body"), md); err != nil {
+		t.Fatal(err)
+	}
+	if ts := md.Get("x-timestamp"); len(ts) != 1 || ts[0] != "1700000000123" {
+		t.Errorf("unexpected timestamp %v", ts)
+	}
+	if len(md.Get("sig")) != 1 || len(md.Get("x-api-key")) != 0 {
+		t.Errorf("unexpected metadata %v", md)
+	}
+	if got := string(s.Canonical("/a.B/C", "1", []byte("body"))); got != "/a.B/C1body" {
+		t.Errorf("the default layout is method, timestamp and body, got %q", got)
+	}
+}
@@ -0,0 +1,125 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.k6.io/k6/metrics"
+	"google.golang.org/grpc/connectivity"
+)
+
+// StateMetrics are the metrics of the connectivity state transitions.
+type StateMetrics struct {
+	// Transitions counts the transitions, tagged with the from and to states.
+	Transitions *metrics.Metric
+	// TimeInState is the time spent in a state when leaving it, tagged with the state.
+	TimeInState *metrics.Metric
+}
+
+// StateTracker tracks the connectivity state transitions of a connection,
+// they are pushed as metrics tagged with the target and logged when Logger is set.
+type StateTracker struct {
+	Target  string
+	Metrics StateMetrics
+	// Samples receives the metrics, with the Tags, nil disables the metrics.
+	Samples chan<- metrics.SampleContainer
+	Tags    *metrics.TagSet
+	// Logger logs the transitions with the last connection error, nil disables the logs.
+	Logger logrus.FieldLogger
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// Dialer returns the dialer recording the connection errors of dial.
+func (t *StateTracker) Dialer(dial DialFunc) DialFunc {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, addr)
+		if err != nil {
+			t.mu.Lock()
+			t.lastErr = err
+			t.mu.Unlock()
+		}
+		return conn, err
+	}
+}
+
+func (t *StateTracker) lastError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastErr
+}
+
+// Track follows the transitions of the connection until it is shut down or ctx is done,
+// it returns immediately and tracks them in the background. The connections opened by
+// conn.WithAuthority are tracked as well.
+func (t *StateTracker) Track(ctx context.Context, conn *Conn) {
+	conn.mu.Lock()
+	conn.track = func(c *Conn) { t.follow(ctx, c) }
+	for _, c := range conn.authorities {
+		t.follow(ctx, c)
+	}
+	conn.mu.Unlock()
+	t.follow(ctx, conn)
+}
+
+func (t *StateTracker) follow(ctx context.Context, conn *Conn) {
+	state, since := conn.State(), time.Now()
+	go func() {
+		for state != connectivity.Shutdown {
+			if !conn.WaitForStateChange(ctx, state) {
+				return
+			}
+			next, now := conn.State(), time.Now()
+			t.transition(ctx, state, next, now.Sub(since), now)
+			state, since = next, now
+		}
+	}()
+}
+
+func (t *StateTracker) transition(ctx context.Context, from, to connectivity.State, d time.Duration, now time.Time) {
+	if t.Logger != nil {
+		logger := t.Logger.WithFields(logrus.Fields{
+			"target":   t.Target,
+			"from":     from.String(),
+			"to":       to.String(),
+			"duration": d,
+		})
+		if err := t.lastError(); err != nil && to == connectivity.TransientFailure {
+			logger = logger.WithError(err)
+		}
+		logger.Info("gRPC connectivity state changed")
+	}
+
+	if t.Samples == nil || t.Tags == nil {
+		return
+	}
+	tags := t.Tags.With("target", t.Target)
+	var samples metrics.Samples
+	if t.Metrics.Transitions != nil {
+		samples = append(samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: t.Metrics.Transitions,
+				Tags:   tags.With("from", from.String()).With("to", to.String()),
+			},
+			Time:  now,
+			Value: 1,
+		})
+	}
+	if t.Metrics.TimeInState != nil {
+		samples = append(samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{
+				Metric: t.Metrics.TimeInState,
+				Tags:   tags.With("state", from.String()),
+			},
+			Time:  now,
+			Value: metrics.D(d),
+		})
+	}
+	if len(samples) > 0 {
+		metrics.PushIfNotDone(ctx, t.Samples, samples)
+	}
+}
@@ -0,0 +1,79 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"go.k6.io/k6/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestStateTracker(t *testing.T) {
+	t.Parallel()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	registry := metrics.NewRegistry()
+	samples := make(chan metrics.SampleContainer, 16)
+	logger, hook := logtest.NewNullLogger()
+	tracker := &StateTracker{
+		Target: "bufnet",
+		Metrics: StateMetrics{
+			Transitions: registry.MustNewMetric("transitions", metrics.Counter),
+			TimeInState: registry.MustNewMetric("time_in_state", metrics.Trend, metrics.Time),
+		},
+		Samples: samples,
+		Tags:    registry.RootTagSet(),
+		Logger:  logger,
+	}
+
+	conn, err := NewClient("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tracker.Track(ctx, conn)
+	conn.Connect()
+
+	// IDLE -> CONNECTING -> READY
+	var transitions []string
+	for len(transitions) < 2 {
+		select {
+		case c := <-samples:
+			for _, s := range c.GetSamples() {
+				if s.Metric.Name != "transitions" {
+					continue
+				}
+				if target, _ := s.Tags.Get("target"); target != "bufnet" {
+					t.Errorf("unexpected target tag %q", target)
+				}
+				from, _ := s.Tags.Get("from")
+				to, _ := s.Tags.Get("to")
+				transitions = append(transitions, from+">"+to)
+			}
+		case <-ctx.Done():
+			t.Fatalf("missing transitions, got %v", transitions)
+		}
+	}
+	if transitions[0] != "IDLE>CONNECTING" || transitions[1] != "CONNECTING>READY" {
+		t.Fatalf("unexpected transitions %v", transitions)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.InfoLevel || entry.Data["to"] != "READY" {
+		t.Fatalf("the transitions should be logged, got %v", entry)
+	}
+}
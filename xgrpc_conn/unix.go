@@ -0,0 +1,40 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/netext"
+)
+
+// UnixSocket returns the socket of an address given to the dialers by grpc-go for the unix
+// targets: unix://absolute-path, unix:relative-path, or \x00name for the unix-abstract ones,
+// which is returned as @name.
+func UnixSocket(addr string) (string, bool) {
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		return strings.TrimPrefix(addr, "unix://"), true
+	case strings.HasPrefix(addr, "unix:"):
+		return strings.TrimPrefix(addr, "unix:"), true
+	case strings.HasPrefix(addr, "\x00"):
+		return "@" + addr[1:], true
+	}
+	return "", false
+}
+
+// dialUnix dials the unix socket with the VU's dialer. The k6 dialer resolves host:port addresses
+// for its blocklists, which don't apply to sockets, so its net.Dialer is used directly while
+// the data sent and received is still counted.
+func dialUnix(ctx context.Context, dialer lib.DialContexter, socket string) (net.Conn, error) {
+	d, ok := dialer.(*netext.Dialer)
+	if !ok {
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+	conn, err := d.Dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	return &netext.Conn{Conn: conn, BytesRead: &d.BytesRead, BytesWritten: &d.BytesWritten}, nil
+}
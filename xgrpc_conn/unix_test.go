@@ -0,0 +1,79 @@
+package xgrpc_conn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/netext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	for addr, expected := range map[string]string{
+		"unix:///var/run/app.sock": "/var/run/app.sock",
+		"unix:app.sock":            "app.sock",
+		"\x00app":                  "@app",
+	} {
+		socket, ok := UnixSocket(addr)
+		if !ok || socket != expected {
+			t.Errorf("%q: expected %s, got %s %v", addr, expected, socket, ok)
+		}
+	}
+	if _, ok := UnixSocket("127.0.0.1:50051"); ok {
+		t.Error("a tcp address isn't a unix socket")
+	}
+}
+
+func TestUnixTargets(t *testing.T) {
+	t.Parallel()
+
+	targets := map[string]string{}
+	path := filepath.Join(t.TempDir(), "app.sock")
+	targets["unix://"+path] = path
+	if runtime.GOOS == "linux" {
+		name := fmt.Sprintf("xk6-grpc-test-%d", time.Now().UnixNano())
+		targets["unix-abstract:"+name] = "@" + name
+	}
+
+	dialer := netext.NewDialer(net.Dialer{}, nil)
+	vu := &modulestest.VU{StateField: &lib.State{Dialer: dialer}}
+	for target, socket := range targets {
+		lis, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := grpc.NewServer()
+		healthpb.RegisterHealthServer(server, health.NewServer())
+		go func() { _ = server.Serve(lis) }()
+		t.Cleanup(server.Stop)
+
+		// the unix sockets are never proxied
+		conn, err := Connect(context.Background(), target, Options{
+			Dialer: VUDialer(vu),
+			Proxy:  ProxyConfig{Lookup: FixedProxy(&url.URL{Scheme: "http", Host: "127.0.0.1:1"})},
+		})
+		if err != nil {
+			t.Fatal(target, err)
+		}
+		if _, err = conn.HealthCheck(context.Background(), ""); err != nil {
+			t.Fatal(target, err)
+		}
+		_ = conn.Close()
+	}
+	if atomic.LoadInt64(&dialer.BytesWritten) == 0 || atomic.LoadInt64(&dialer.BytesRead) == 0 {
+		t.Error("the data of the unix sockets should be counted by the VU's dialer")
+	}
+}